@@ -0,0 +1,271 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// DryRunMode controls whether expired objects are deleted
+// +kubebuilder:validation:Enum=None;Client;Server
+type DryRunMode string
+
+const (
+	// DryRunNone deletes expired objects
+	DryRunNone DryRunMode = "None"
+	// DryRunClient logs and raises WouldReap events instead of deleting
+	DryRunClient DryRunMode = "Client"
+	// DryRunServer also issues a server-side dry-run delete
+	DryRunServer DryRunMode = "Server"
+)
+
+// ExpiryAction is what is done to an expired object
+// +kubebuilder:validation:Enum=Delete;ScaleToZero;Suspend;Patch;Label;Evict
+type ExpiryAction string
+
+const (
+	// ActionDelete deletes the object
+	ActionDelete ExpiryAction = "Delete"
+	// ActionScaleToZero scales a workload to zero replicas through its scale subresource
+	ActionScaleToZero ExpiryAction = "ScaleToZero"
+	// ActionSuspend sets spec.suspend, e.g. of a CronJob or Job
+	ActionSuspend ExpiryAction = "Suspend"
+	// ActionPatch applies the patch of the kind
+	ActionPatch ExpiryAction = "Patch"
+	// ActionLabel adds the expired label
+	ActionLabel ExpiryAction = "Label"
+	// ActionEvict evicts a Pod through the Eviction API, respecting PodDisruptionBudgets
+	ActionEvict ExpiryAction = "Evict"
+)
+
+// PatchType of the patch action
+// +kubebuilder:validation:Enum=JSON;Merge
+type PatchType string
+
+const (
+	// PatchJSON is a JSON patch, RFC 6902
+	PatchJSON PatchType = "JSON"
+	// PatchMerge is a JSON merge patch, RFC 7386
+	PatchMerge PatchType = "Merge"
+)
+
+// Condition types of a TtlReaperPolicy
+const (
+	// ConditionValid is true when the policy spec is valid
+	ConditionValid = "Valid"
+	// ConditionLastSweep is true when the last sweep completed without errors
+	ConditionLastSweep = "LastSweep"
+	// ConditionErrors is true when the last sweep had errors
+	ConditionErrors = "Errors"
+)
+
+// KindRule selects a kind of object to reap and how
+type KindRule struct {
+	// Group of the kind, empty for the core group
+	// +optional
+	Group string `json:"group,omitempty"`
+	// Version of the kind
+	// +kubebuilder:validation:MinLength=1
+	Version string `json:"version"`
+	// Kind to reap
+	// +kubebuilder:validation:MinLength=1
+	Kind string `json:"kind"`
+	// TtlStart anchors the TTL countdown, one of creation (default), label, last-update or condition:<Type>
+	// +kubebuilder:validation:Pattern=`^(creation|label|last-update|condition:.+)$`
+	// +optional
+	TtlStart string `json:"ttlStart,omitempty"`
+	// MaxLifetime overrides the policy max lifetime for this kind
+	// +optional
+	MaxLifetime string `json:"maxLifetime,omitempty"`
+	// ListUnlabeled lists and watches every object of the kind rather than only those with
+	// the TTL label, for objects with only an expires-at annotation or a tenant default TTL
+	// +optional
+	ListUnlabeled bool `json:"listUnlabeled,omitempty"`
+	// Timeout overrides the policy sweep timeout for this kind
+	// +optional
+	Timeout string `json:"timeout,omitempty"`
+	// DryRun overrides the policy dry run mode for this kind
+	// +optional
+	DryRun DryRunMode `json:"dryRun,omitempty"`
+	// Interval overrides the policy check interval for this kind
+	// +optional
+	Interval string `json:"interval,omitempty"`
+	// NamePrefixes only reaps objects whose name has one of the prefixes, overrides the policy name prefix
+	// +optional
+	NamePrefixes []string `json:"namePrefixes,omitempty"`
+	// NameRegexes only reaps objects whose name matches one of the regular expressions, in addition
+	// to NamePrefixes, overrides the policy name prefix
+	// +optional
+	NameRegexes []string `json:"nameRegexes,omitempty"`
+	// Selector only reaps objects with matching labels, the sweep lists with it
+	// +optional
+	Selector *metav1.LabelSelector `json:"selector,omitempty"`
+	// FieldSelector only reaps objects matching the field selector, e.g. status.phase=Succeeded
+	// +optional
+	FieldSelector string `json:"fieldSelector,omitempty"`
+	// Namespaces overrides the policy namespaces for this kind, names or globs
+	// +optional
+	Namespaces []string `json:"namespaces,omitempty"`
+	// NamespaceSelector overrides the policy namespace selector for this kind
+	// +optional
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+	// Condition is a CEL expression on the object that must hold for it to be reaped once
+	// expired, e.g. object.status.phase == 'Succeeded'
+	// +optional
+	Condition string `json:"condition,omitempty"`
+	// Action taken on expired objects, defaults to Delete
+	// +optional
+	Action ExpiryAction `json:"action,omitempty"`
+	// Patch applied by the Patch action
+	// +optional
+	Patch string `json:"patch,omitempty"`
+	// PatchType of Patch, defaults to Merge
+	// +optional
+	PatchType PatchType `json:"patchType,omitempty"`
+	// PropagationPolicy of the deletion of expired objects, defaults to the kind's, usually Background
+	// +kubebuilder:validation:Enum=Foreground;Background;Orphan
+	// +optional
+	PropagationPolicy metav1.DeletionPropagation `json:"propagationPolicy,omitempty"`
+	// GracePeriodSeconds of the deletion of expired objects, defaults to the object's
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	GracePeriodSeconds *int64 `json:"gracePeriodSeconds,omitempty"`
+	// WarnBefore overrides the policy warning thresholds for this kind
+	// +optional
+	WarnBefore []string `json:"warnBefore,omitempty"`
+}
+
+// TtlReaperPolicySpec defines the desired state of TtlReaperPolicy
+type TtlReaperPolicySpec struct {
+	// CheckInterval of the safety net sweep, e.g. 5m
+	// +kubebuilder:validation:MinLength=1
+	CheckInterval string `json:"checkInterval"`
+	// NamePrefix only reaps objects whose name has the prefix
+	// +optional
+	NamePrefix string `json:"namePrefix,omitempty"`
+	// Namespaces to reap in, names or globs, all namespaces when empty and NamespaceSelector
+	// is unset. kube-system, kube-public and the operator namespace must be listed by name
+	// +optional
+	Namespaces []string `json:"namespaces,omitempty"`
+	// NamespaceSelector also reaps in namespaces with matching labels
+	// +optional
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+	// ExcludeNamespaces never to reap in, names or globs, takes precedence over Namespaces
+	// +optional
+	ExcludeNamespaces []string `json:"excludeNamespaces,omitempty"`
+	// ExcludeNamespaceSelector never reaps in namespaces with matching labels
+	// +optional
+	ExcludeNamespaceSelector *metav1.LabelSelector `json:"excludeNamespaceSelector,omitempty"`
+	// MaxLifetime caps how long renewals can keep an object alive, measured from creation
+	// +optional
+	MaxLifetime string `json:"maxLifetime,omitempty"`
+	// DryRun mode of all kinds, defaults to None
+	// +optional
+	DryRun DryRunMode `json:"dryRun,omitempty"`
+	// PageSize is the number of objects fetched per page by the sweep, defaults to 500
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	PageSize int64 `json:"pageSize,omitempty"`
+	// SweepWorkers is the number of kinds swept concurrently, defaults to 4
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	SweepWorkers int32 `json:"sweepWorkers,omitempty"`
+	// SweepTimeout is how long the sweep of a single kind may take, defaults to 5m
+	// +optional
+	SweepTimeout string `json:"sweepTimeout,omitempty"`
+	// WarnBefore are durations before expiry at which an ExpiringSoon warning is given, e.g. 24h and 1h
+	// +optional
+	WarnBefore []string `json:"warnBefore,omitempty"`
+	// Kinds to reap
+	// +optional
+	Kinds []KindRule `json:"kinds,omitempty"`
+}
+
+// SweepSummary summarises a sweep of all kinds
+type SweepSummary struct {
+	// StartTime of the sweep
+	StartTime metav1.Time `json:"startTime"`
+	// CompletionTime of the sweep
+	CompletionTime metav1.Time `json:"completionTime"`
+	// Kinds swept
+	Kinds int32 `json:"kinds"`
+	// Matched objects with a TTL
+	Matched int32 `json:"matched"`
+	// Reaped expired objects
+	Reaped int32 `json:"reaped"`
+	// WouldReap expired objects not deleted because of dry run
+	WouldReap int32 `json:"wouldReap"`
+	// Failed deletes of or actions on expired objects
+	Failed int32 `json:"failed"`
+	// Invalid TTL labels or annotations
+	Invalid int32 `json:"invalid"`
+	// Skipped expired objects whose condition doesn't hold
+	Skipped int32 `json:"skipped"`
+	// Actioned expired objects an action other than delete was taken on
+	Actioned int32 `json:"actioned"`
+	// PendingDeletion expired objects whose deletion hasn't completed, e.g. a foreground
+	// deletion waiting on dependents
+	PendingDeletion int32 `json:"pendingDeletion"`
+	// FailedKinds that could not be swept
+	// +optional
+	FailedKinds []string `json:"failedKinds,omitempty"`
+}
+
+// TtlReaperPolicyStatus defines the observed state of TtlReaperPolicy
+type TtlReaperPolicyStatus struct {
+	// ObservedGeneration of the spec last processed
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// Conditions of the policy, Valid, LastSweep and Errors
+	// +listType=map
+	// +listMapKey=type
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type"`
+	// LastSweepSummary of the last periodic sweep
+	// +optional
+	LastSweepSummary *SweepSummary `json:"lastSweepSummary,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:scope=Cluster
+// +kubebuilder:printcolumn:name="Interval",type=string,JSONPath=`.spec.checkInterval`
+// +kubebuilder:printcolumn:name="Valid",type=string,JSONPath=`.status.conditions[?(@.type=="Valid")].status`
+// +kubebuilder:printcolumn:name="Last Sweep",type=date,JSONPath=`.status.lastSweepSummary.completionTime`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// TtlReaperPolicy is the Schema for the ttlreaperpolicies API
+type TtlReaperPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   TtlReaperPolicySpec   `json:"spec,omitempty"`
+	Status TtlReaperPolicyStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// TtlReaperPolicyList contains a list of TtlReaperPolicy
+type TtlReaperPolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []TtlReaperPolicy `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&TtlReaperPolicy{}, &TtlReaperPolicyList{})
+}
@@ -0,0 +1,96 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// TenantKindRule selects a kind of object to reap in the namespace of the policy.
+// The kind must be enabled by the cluster policy, whose rule for the kind it narrows
+type TenantKindRule struct {
+	// Group of the kind, empty for the core group
+	// +optional
+	Group string `json:"group,omitempty"`
+	// Version of the kind
+	// +kubebuilder:validation:MinLength=1
+	Version string `json:"version"`
+	// Kind to reap, it must be namespaced
+	// +kubebuilder:validation:MinLength=1
+	Kind string `json:"kind"`
+	// Selector limits the rule to objects with matching labels, all objects of the kind when empty
+	// +optional
+	Selector *metav1.LabelSelector `json:"selector,omitempty"`
+	// DefaultTtl applies to selected objects with neither a TTL label nor an expires-at annotation
+	// +optional
+	DefaultTtl string `json:"defaultTtl,omitempty"`
+	// TtlStart anchors the TTL countdown, it must be the cluster policy's, one of creation (default),
+	// label, last-update or condition:<Type>
+	// +kubebuilder:validation:Pattern=`^(creation|label|last-update|condition:.+)$`
+	// +optional
+	TtlStart string `json:"ttlStart,omitempty"`
+	// MaxLifetime caps how long renewals can keep an object alive, it can't exceed the cluster policy
+	// +optional
+	MaxLifetime string `json:"maxLifetime,omitempty"`
+}
+
+// TtlReaperTenantPolicySpec defines the desired state of TtlReaperTenantPolicy
+type TtlReaperTenantPolicySpec struct {
+	// Kinds to reap in the namespace of the policy
+	// +optional
+	Kinds []TenantKindRule `json:"kinds,omitempty"`
+}
+
+// TtlReaperTenantPolicyStatus defines the observed state of TtlReaperTenantPolicy
+type TtlReaperTenantPolicyStatus struct {
+	// ObservedGeneration of the spec last processed
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// Conditions of the policy, Valid is false when the policy was rejected
+	// +listType=map
+	// +listMapKey=type
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Valid",type=string,JSONPath=`.status.conditions[?(@.type=="Valid")].status`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// TtlReaperTenantPolicy is the Schema for the ttlreapertenantpolicies API,
+// it lets a team reap objects in its own namespace
+type TtlReaperTenantPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   TtlReaperTenantPolicySpec   `json:"spec,omitempty"`
+	Status TtlReaperTenantPolicyStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// TtlReaperTenantPolicyList contains a list of TtlReaperTenantPolicy
+type TtlReaperTenantPolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []TtlReaperTenantPolicy `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&TtlReaperTenantPolicy{}, &TtlReaperTenantPolicyList{})
+}
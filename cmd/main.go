@@ -17,37 +17,49 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"crypto/tls"
 	"flag"
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 	"sigs.k8s.io/controller-runtime/pkg/metrics/filters"
 	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
 
+	"kubettlreaper/api/v1alpha1"
+	"kubettlreaper/internal/archive"
+	"kubettlreaper/internal/audit"
 	"kubettlreaper/internal/controller"
+	"kubettlreaper/internal/notify"
 	// +kubebuilder:scaffold:imports
 )
 
 var (
 	scheme   = runtime.NewScheme()
 	setupLog = ctrl.Log.WithName("setup")
+	// version is set at build time with -ldflags "-X main.version=..."
+	version = "dev"
 )
 
 func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	utilruntime.Must(v1alpha1.AddToScheme(scheme))
 
 	// +kubebuilder:scaffold:scheme
 }
@@ -60,6 +72,15 @@ func main() {
 	var enableHTTP2 bool
 	var tlsOpts []func(*tls.Config)
 	var configurationName string
+	var migrateConfiguration bool
+	var notifierOpts notifierOptions
+	var auditLog string
+	var auditLogMaxSize int64
+	var auditLogMaxBackups int
+	var verifyAuditLog string
+	var archiveOpts archive.Options
+	var archiveRetention time.Duration
+	var restoreUID string
 	flag.StringVar(&metricsAddr, "metrics-bind-address", "0", "The address the metrics endpoint binds to. "+
 		"Use :8443 for HTTPS or :8080 for HTTP, or leave as 0 to disable the metrics service.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
@@ -70,7 +91,52 @@ func main() {
 		"If set, the metrics endpoint is served securely via HTTPS. Use --metrics-secure=false to use HTTP instead.")
 	flag.BoolVar(&enableHTTP2, "enable-http2", false,
 		"If set, HTTP/2 will be enabled for the metrics and webhook servers")
-	flag.StringVar(&configurationName, "configuration-name", "kube-ttl-reaper", "name of the configMap of kinds to reap")
+	flag.StringVar(&configurationName, "configuration-name", "kube-ttl-reaper",
+		"comma separated names of the TtlReaperPolicies, or configMaps in the operator namespace, of kinds to reap. "+
+			"Configurations labelled "+controller.ConfigurationLabel+"=true are also run")
+	flag.BoolVar(&migrateConfiguration, "migrate-configuration", false,
+		"If set, create a TtlReaperPolicy from the configMap when there is none")
+	flag.StringVar(&notifierOpts.webhookURLs, "notification-webhook-url", "",
+		"comma separated URLs to POST notifications about expiring and reaped objects to. "+
+			"Requests are signed when the NOTIFICATION_WEBHOOK_SECRET env var is set")
+	flag.IntVar(&notifierOpts.retries, "notification-webhook-retries", 3,
+		"Retries of a failed notification webhook request")
+	flag.DurationVar(&notifierOpts.timeout, "notification-webhook-timeout", 10*time.Second,
+		"Timeout of a notification webhook request")
+	flag.StringVar(&notifierOpts.cloudEventsSinkURL, "cloudevents-sink-url", "",
+		"URL to POST CloudEvents about expiring, reaped, failed and skipped objects to, e.g. a Knative broker")
+	flag.StringVar(&notifierOpts.cloudEventsSource, "cloudevents-source", notify.DefaultEventSource,
+		"Source attribute of the CloudEvents, e.g. identifying the cluster")
+	flag.StringVar(&notifierOpts.cloudEventsMode, "cloudevents-mode", notify.ModeBinary,
+		"Content mode of the CloudEvents HTTP requests, "+notify.ModeBinary+" or "+notify.ModeStructured)
+	flag.StringVar(&auditLog, "audit-log", "",
+		"File to append a hash chained JSON record of every action taken on expired objects to, e.g. on a mounted volume, "+
+			"or "+audit.Stdout+" for stdout. Disabled when empty")
+	flag.Int64Var(&auditLogMaxSize, "audit-log-max-size", 100,
+		"Size in MiB an audit log file is rotated at, 0 never rotates it")
+	flag.IntVar(&auditLogMaxBackups, "audit-log-max-backups", 10,
+		"Rotated audit log files to keep, at least 1 unless --audit-log-max-size is 0")
+	flag.StringVar(&verifyAuditLog, "verify-audit-log", "",
+		"If set, verify the hash chain of the audit log file and its rotated files, then exit")
+	flag.StringVar(&archiveOpts.Backend, "archive-backend", "",
+		"If set, archive expired objects before deleting them to "+archive.BackendDir+", "+archive.BackendConfigMap+", "+
+			archive.BackendSecret+" or "+archive.BackendS3+". An object that can't be archived isn't deleted")
+	flag.StringVar(&archiveOpts.Dir, "archive-dir", "/var/lib/kubettlreaper/archive",
+		"Directory of the dir archive, e.g. on a mounted volume")
+	flag.StringVar(&archiveOpts.Namespace, "archive-namespace", controller.OperatorNamespace,
+		"Namespace of the ConfigMaps or Secrets of the configmap and secret archives")
+	flag.StringVar(&archiveOpts.S3.Endpoint, "archive-s3-endpoint", "",
+		"Endpoint of the S3-compatible API of the s3 archive, credentials are read from the AWS_ACCESS_KEY_ID, "+
+			"AWS_SECRET_ACCESS_KEY and optional AWS_SESSION_TOKEN env vars")
+	flag.StringVar(&archiveOpts.S3.Bucket, "archive-s3-bucket", "", "Bucket of the s3 archive")
+	flag.StringVar(&archiveOpts.S3.Prefix, "archive-s3-prefix", "kubettlreaper/",
+		"Prefix of the object keys of the s3 archive")
+	flag.StringVar(&archiveOpts.S3.Region, "archive-s3-region", "us-east-1",
+		"Region the s3 archive requests are signed for")
+	flag.DurationVar(&archiveRetention, "archive-retention", 30*24*time.Hour,
+		"How long archived objects are kept, 0 keeps them forever")
+	flag.StringVar(&restoreUID, "restore-uid", "",
+		"If set, restore the archived object of this UID without its TTL, then exit")
 	// Read DEBUG_LOG from env var
 	debugLog, logVarErr := strconv.ParseBool(os.Getenv("DEBUG_LOG"))
 	if logVarErr != nil {
@@ -85,11 +151,43 @@ func main() {
 
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 
+	if verifyAuditLog != "" {
+		records, lastHash, err := audit.VerifyFiles(verifyAuditLog)
+		if err != nil {
+			setupLog.Error(err, "audit log verification failed", "path", verifyAuditLog)
+			os.Exit(1)
+		}
+		setupLog.Info("audit log verified", "path", verifyAuditLog, "records", records, "lastHash", lastHash)
+		os.Exit(0)
+	}
+
 	if len(configurationName) == 0 {
 		setupLog.Error(fmt.Errorf("missing JitRbacOperator configuration resource name"), "unable to start manager")
 		os.Exit(1)
 	}
 
+	archiveStore, archiveClient, err := newArchive(archiveOpts)
+	if err != nil {
+		setupLog.Error(err, "unable to create archive", "backend", archiveOpts.Backend)
+		os.Exit(1)
+	}
+
+	if restoreUID != "" {
+		if archiveStore == nil {
+			setupLog.Error(fmt.Errorf("--archive-backend is required"), "unable to restore", "uid", restoreUID)
+			os.Exit(1)
+		}
+		obj, err := archive.Restore(context.Background(), archiveClient, archiveStore, types.UID(restoreUID),
+			controller.RestoreOptions)
+		if err != nil {
+			setupLog.Error(err, "unable to restore", "uid", restoreUID)
+			os.Exit(1)
+		}
+		setupLog.Info("restored archived object", "archivedUID", restoreUID, "kind", obj.GetKind(),
+			"namespace", obj.GetNamespace(), "name", obj.GetName(), "uid", obj.GetUID())
+		os.Exit(0)
+	}
+
 	// if the enable-http2 flag is false (the default), http/2 should be disabled
 	// due to its vulnerabilities. More specifically, disabling http/2 will
 	// prevent from being vulnerable to the HTTP/2 Stream Cancellation and
@@ -155,28 +253,159 @@ func main() {
 		os.Exit(1)
 	}
 
+	if archiveStore != nil {
+		secure := metricsAddr != "0" && secureMetrics
+		if err := serveArchive(mgr, archiveClient, archiveStore, archiveRetention, secure); err != nil {
+			setupLog.Error(err, "unable to serve the archive")
+			os.Exit(1)
+		}
+	}
+
+	recorder, err := controller.NewEventRecorder(mgr)
+	if err != nil {
+		setupLog.Error(err, "unable to create event recorder")
+		os.Exit(1)
+	}
+	notifier, err := newNotifier(notifierOpts)
+	if err != nil {
+		setupLog.Error(err, "unable to create notifier")
+		os.Exit(1)
+	}
+
+	var auditor *audit.Log
+	if auditLog != "" {
+		auditor, err = audit.Open(auditLog, version, auditLogMaxSize<<20, auditLogMaxBackups)
+		if err != nil {
+			setupLog.Error(err, "unable to open audit log", "path", auditLog)
+			os.Exit(1)
+		}
+		if truncated := auditor.Truncated(); truncated > 0 {
+			setupLog.Info("dropped an incomplete last audit record", "path", auditLog, "bytes", truncated)
+		}
+	}
+	// exit closes the audit log first, deferred calls don't run on os.Exit
+	exit := func(code int) {
+		if auditor != nil {
+			if err := auditor.Close(); err != nil {
+				setupLog.Error(err, "unable to close audit log", "path", auditLog)
+			}
+		}
+		os.Exit(code)
+	}
+
 	if err = (&controller.TtlReaperReconciler{
 		Client:   mgr.GetClient(),
 		Scheme:   mgr.GetScheme(),
-		Recorder: mgr.GetEventRecorderFor("kubettlreaper-controller"),
-	}).SetupWithManager(mgr, configurationName); err != nil {
+		Recorder: recorder,
+		Notifier: notifier,
+		AuditLog: auditor,
+		Archive:  archiveStore,
+
+		MigrateConfiguration: migrateConfiguration,
+	}).SetupWithManager(mgr, strings.Split(configurationName, ",")...); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "TtlReaper")
-		os.Exit(1)
+		exit(1)
 	}
 	// +kubebuilder:scaffold:builder
 
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
 		setupLog.Error(err, "unable to set up health check")
-		os.Exit(1)
+		exit(1)
 	}
 	if err := mgr.AddReadyzCheck("readyz", healthz.Ping); err != nil {
 		setupLog.Error(err, "unable to set up ready check")
-		os.Exit(1)
+		exit(1)
 	}
 
-	setupLog.Info("starting manager")
+	setupLog.Info("starting manager", "version", version)
 	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
 		setupLog.Error(err, "problem running manager")
-		os.Exit(1)
+		exit(1)
+	}
+	exit(0)
+}
+
+// newArchive returns the archive store of the options and the client it restores objects
+// with, none when no backend is set
+func newArchive(opts archive.Options) (archive.Store, client.Client, error) {
+	if opts.Backend == "" {
+		return nil, nil, nil
+	}
+	opts.S3.AccessKeyID = os.Getenv("AWS_ACCESS_KEY_ID")
+	opts.S3.SecretAccessKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+	opts.S3.SessionToken = os.Getenv("AWS_SESSION_TOKEN")
+	// The archive isn't cached, ConfigMaps and Secrets are read from the API server
+	c, err := client.New(ctrl.GetConfigOrDie(), client.Options{Scheme: scheme})
+	if err != nil {
+		return nil, nil, err
+	}
+	store, err := archive.New(opts, c)
+	if err != nil {
+		return nil, nil, err
+	}
+	return store, c, nil
+}
+
+// serveArchive serves the archive's HTTP API, only behind the secure metrics endpoint's
+// authn/authz and never in plain HTTP, and prunes it
+func serveArchive(mgr ctrl.Manager, c client.Client, store archive.Store, retention time.Duration, secure bool) error {
+	if secure {
+		if err := mgr.AddMetricsServerExtraHandler("/archive/",
+			archive.Handler(c, store, controller.RestoreOptions)); err != nil {
+			return err
+		}
+	} else {
+		setupLog.Info("archive HTTP API disabled, it requires --metrics-secure and a --metrics-bind-address")
+	}
+	if retention > 0 {
+		return mgr.Add(&archive.Pruner{Store: store, Retention: retention, Interval: time.Hour})
+	}
+	return nil
+}
+
+// notifierOptions are the flags of the notifiers
+type notifierOptions struct {
+	webhookURLs        string
+	retries            int
+	timeout            time.Duration
+	cloudEventsSinkURL string
+	cloudEventsSource  string
+	cloudEventsMode    string
+}
+
+// newNotifier returns the webhook and CloudEvents notifiers configured, nil when none is
+func newNotifier(opts notifierOptions) (notify.Notifier, error) {
+	var notifiers notify.Multi
+	if opts.webhookURLs != "" {
+		webhook, err := notify.NewWebhook(notify.WebhookOptions{
+			URLs:    strings.Split(opts.webhookURLs, ","),
+			Secret:  []byte(os.Getenv("NOTIFICATION_WEBHOOK_SECRET")),
+			Timeout: opts.timeout,
+			Retries: opts.retries,
+		})
+		if err != nil {
+			return nil, err
+		}
+		notifiers = append(notifiers, webhook)
+	}
+	if opts.cloudEventsSinkURL != "" {
+		cloudEvents, err := notify.NewCloudEvents(notify.CloudEventsOptions{
+			SinkURL: opts.cloudEventsSinkURL,
+			Source:  opts.cloudEventsSource,
+			Mode:    opts.cloudEventsMode,
+			Timeout: opts.timeout,
+			Retries: opts.retries,
+		})
+		if err != nil {
+			return nil, err
+		}
+		notifiers = append(notifiers, cloudEvents)
+	}
+	switch len(notifiers) {
+	case 0:
+		return nil, nil
+	case 1:
+		return notifiers[0], nil
 	}
+	return notifiers, nil
 }
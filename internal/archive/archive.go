@@ -0,0 +1,167 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package archive
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// Backends of the archive
+const (
+	BackendDir       = "dir"
+	BackendConfigMap = "configmap"
+	BackendSecret    = "secret"
+	BackendS3        = "s3"
+)
+
+// ErrNotFound is returned for a UID that isn't archived, or no longer is
+var ErrNotFound = errors.New("not archived")
+
+// Snapshot is an archived object, the manifest it can be recreated from
+type Snapshot struct {
+	UID        types.UID                  `json:"uid"`
+	APIVersion string                     `json:"apiVersion"`
+	Kind       string                     `json:"kind"`
+	Namespace  string                     `json:"namespace,omitempty"`
+	Name       string                     `json:"name"`
+	ArchivedAt time.Time                  `json:"archivedAt"`
+	Object     *unstructured.Unstructured `json:"object"`
+}
+
+// isSecret reports whether a snapshot is of a Secret
+func (s Snapshot) isSecret() bool {
+	return s.APIVersion == "v1" && s.Kind == "Secret"
+}
+
+// NewSnapshot returns the snapshot of an object about to be deleted, its manifest cleaned of the
+// status and the fields the API server sets
+func NewSnapshot(obj *unstructured.Unstructured) Snapshot {
+	return Snapshot{
+		UID:        obj.GetUID(),
+		APIVersion: obj.GetAPIVersion(),
+		Kind:       obj.GetKind(),
+		Namespace:  obj.GetNamespace(),
+		Name:       obj.GetName(),
+		ArchivedAt: time.Now().UTC(),
+		Object:     Clean(obj),
+	}
+}
+
+// Clean returns a copy of an object without its status and the fields the API server sets,
+// so it can be created again. Owner references are dropped as the owner may be gone too
+func Clean(obj *unstructured.Unstructured) *unstructured.Unstructured {
+	cleaned := obj.DeepCopy()
+	unstructured.RemoveNestedField(cleaned.Object, "status")
+	for _, field := range []string{"uid", "resourceVersion", "generation", "creationTimestamp", "deletionTimestamp",
+		"deletionGracePeriodSeconds", "managedFields", "selfLink", "ownerReferences"} {
+		unstructured.RemoveNestedField(cleaned.Object, "metadata", field)
+	}
+	// A Service's cluster IPs are allocated again, the old ones may be taken
+	if cleaned.GetAPIVersion() == "v1" && cleaned.GetKind() == "Service" {
+		unstructured.RemoveNestedField(cleaned.Object, "spec", "clusterIP")
+		unstructured.RemoveNestedField(cleaned.Object, "spec", "clusterIPs")
+	}
+	// A Job's generated selector matches the controller-uid of the old Job, the new one gets its own
+	if cleaned.GetAPIVersion() == "batch/v1" && cleaned.GetKind() == "Job" {
+		if manual, _, _ := unstructured.NestedBool(cleaned.Object, "spec", "manualSelector"); !manual {
+			unstructured.RemoveNestedField(cleaned.Object, "spec", "selector")
+			for _, label := range []string{"controller-uid", "batch.kubernetes.io/controller-uid"} {
+				unstructured.RemoveNestedField(cleaned.Object, "metadata", "labels", label)
+				unstructured.RemoveNestedField(cleaned.Object, "spec", "template", "metadata", "labels", label)
+			}
+		}
+	}
+	return cleaned
+}
+
+// Store keeps archived objects by UID
+type Store interface {
+	// Put archives a snapshot, replacing one of the same UID
+	Put(ctx context.Context, snapshot Snapshot) error
+	// Get returns the snapshot of a UID, ErrNotFound if there is none
+	Get(ctx context.Context, uid types.UID) (Snapshot, error)
+	// Prune deletes the snapshots archived before a time and returns how many were
+	Prune(ctx context.Context, before time.Time) (int, error)
+}
+
+// Options configure the archive store
+type Options struct {
+	// Backend is BackendDir, BackendConfigMap, BackendSecret or BackendS3
+	Backend string
+	// Dir of BackendDir, e.g. a mounted volume
+	Dir string
+	// Namespace of the ConfigMaps or Secrets of BackendConfigMap and BackendSecret
+	Namespace string
+	// S3 configures BackendS3
+	S3 S3Options
+}
+
+// New returns the store of a backend, the ConfigMap and Secret backends use the client,
+// which should read from the API server rather than a cache
+func New(opts Options, c client.Client) (Store, error) {
+	switch opts.Backend {
+	case BackendDir:
+		return NewDir(opts.Dir)
+	case BackendConfigMap, BackendSecret:
+		return NewObjects(c, opts.Namespace, opts.Backend == BackendSecret)
+	case BackendS3:
+		return NewS3(opts.S3)
+	}
+	return nil, fmt.Errorf("invalid archive backend %q, expected one of %s, %s, %s or %s", opts.Backend,
+		BackendDir, BackendConfigMap, BackendSecret, BackendS3)
+}
+
+// Pruner deletes the snapshots older than the retention period, it runs on the leader
+type Pruner struct {
+	Store     Store
+	Retention time.Duration
+	Interval  time.Duration
+}
+
+// Start prunes the store every interval until the context is done
+func (p *Pruner) Start(ctx context.Context) error {
+	l := log.FromContext(ctx).WithName("archive")
+	ticker := time.NewTicker(p.Interval)
+	defer ticker.Stop()
+	for {
+		pruned, err := p.Store.Prune(ctx, time.Now().Add(-p.Retention))
+		if err != nil {
+			l.Error(err, "Failed to prune the archive")
+		} else if pruned > 0 {
+			l.Info("Pruned the archive", "snapshots", pruned, "retention", p.Retention)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// NeedLeaderElection prunes on the leader only
+func (p *Pruner) NeedLeaderElection() bool {
+	return true
+}
@@ -0,0 +1,387 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package archive
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+// roleBinding returns a RoleBinding as read from the API server, about to be reaped
+func roleBinding() *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{Object: map[string]any{
+		"apiVersion": "rbac.authorization.k8s.io/v1",
+		"kind":       "RoleBinding",
+		"metadata": map[string]any{
+			"namespace":         "ci",
+			"name":              "deployers",
+			"uid":               "1234",
+			"resourceVersion":   "42",
+			"generation":        int64(1),
+			"creationTimestamp": "2024-11-01T17:00:00Z",
+			"labels":            map[string]any{"kubettlreaper.samir.io/ttl": "1h", "team": "ci"},
+			"managedFields":     []any{map[string]any{"manager": "kubectl"}},
+			"ownerReferences":   []any{map[string]any{"kind": "Namespace", "name": "ci", "uid": "5678"}},
+		},
+		"roleRef":  map[string]any{"apiGroup": "rbac.authorization.k8s.io", "kind": "ClusterRole", "name": "edit"},
+		"subjects": []any{map[string]any{"kind": "Group", "name": "deployers"}},
+	}}
+	return obj
+}
+
+// storeBehaviour are the specs every store passes
+func storeBehaviour(newStore func() Store) {
+	var (
+		store Store
+		ctx   = context.Background()
+	)
+
+	BeforeEach(func() {
+		store = newStore()
+	})
+
+	It("should put and get snapshots by UID", func() {
+		snapshot := NewSnapshot(roleBinding())
+		Expect(store.Put(ctx, snapshot)).To(Succeed())
+		// Archiving the same object again replaces its snapshot
+		Expect(store.Put(ctx, snapshot)).To(Succeed())
+
+		got, err := store.Get(ctx, "1234")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(got.UID).To(BeEquivalentTo("1234"))
+		Expect(got.Kind).To(Equal("RoleBinding"))
+		Expect(got.ArchivedAt).To(BeTemporally("~", snapshot.ArchivedAt, time.Second))
+		Expect(got.Object.Object).To(Equal(snapshot.Object.Object))
+
+		_, err = store.Get(ctx, "unknown")
+		Expect(err).To(MatchError(ErrNotFound))
+	})
+
+	It("should prune snapshots older than the retention period", func() {
+		Expect(store.Put(ctx, NewSnapshot(roleBinding()))).To(Succeed())
+
+		pruned, err := store.Prune(ctx, time.Now().Add(-time.Hour))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pruned).To(BeZero())
+
+		pruned, err = store.Prune(ctx, time.Now().Add(time.Hour))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pruned).To(Equal(1))
+		_, err = store.Get(ctx, "1234")
+		Expect(err).To(MatchError(ErrNotFound))
+	})
+}
+
+// newFakeClient returns a fake client of the core and RBAC types
+func newFakeClient(objs ...client.Object) client.Client {
+	scheme := runtime.NewScheme()
+	Expect(corev1.AddToScheme(scheme)).To(Succeed())
+	return fake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).Build()
+}
+
+var _ = Describe("Clean", func() {
+	It("should strip the status and server fields", func() {
+		obj := roleBinding()
+		obj.Object["status"] = map[string]any{"phase": "Active"}
+
+		cleaned := Clean(obj)
+		Expect(cleaned.Object).NotTo(HaveKey("status"))
+		Expect(cleaned.GetUID()).To(BeEmpty())
+		Expect(cleaned.GetResourceVersion()).To(BeEmpty())
+		Expect(cleaned.GetGeneration()).To(BeZero())
+		Expect(cleaned.GetManagedFields()).To(BeEmpty())
+		Expect(cleaned.GetOwnerReferences()).To(BeEmpty())
+		Expect(cleaned.Object["metadata"]).NotTo(HaveKey("creationTimestamp"))
+		Expect(cleaned.GetLabels()).To(HaveKeyWithValue("team", "ci"))
+		Expect(cleaned.Object).To(HaveKey("subjects"))
+		// The object itself is untouched
+		Expect(obj.GetUID()).To(BeEquivalentTo("1234"))
+	})
+
+	It("should strip the generated selector and controller-uid labels of a Job", func() {
+		labels := map[string]any{
+			"controller-uid":                     "1234",
+			"batch.kubernetes.io/controller-uid": "1234",
+			"batch.kubernetes.io/job-name":       "tmp-ttl-migrate",
+		}
+		job := &unstructured.Unstructured{Object: map[string]any{
+			"apiVersion": "batch/v1",
+			"kind":       "Job",
+			"metadata":   map[string]any{"name": "tmp-ttl-migrate", "namespace": "ci", "labels": labels},
+			"spec": map[string]any{
+				"selector": map[string]any{"matchLabels": map[string]any{"batch.kubernetes.io/controller-uid": "1234"}},
+				"template": map[string]any{"metadata": map[string]any{"labels": runtime.DeepCopyJSONValue(labels)}},
+			},
+		}}
+
+		cleaned := Clean(job)
+		Expect(cleaned.Object["spec"]).NotTo(HaveKey("selector"))
+		Expect(cleaned.GetLabels()).To(Equal(map[string]string{"batch.kubernetes.io/job-name": "tmp-ttl-migrate"}))
+		templateLabels, _, _ := unstructured.NestedStringMap(cleaned.Object, "spec", "template", "metadata", "labels")
+		Expect(templateLabels).To(Equal(map[string]string{"batch.kubernetes.io/job-name": "tmp-ttl-migrate"}))
+
+		By("keeping a manual selector")
+		Expect(unstructured.SetNestedField(job.Object, true, "spec", "manualSelector")).To(Succeed())
+		Expect(Clean(job).Object["spec"]).To(HaveKey("selector"))
+	})
+})
+
+var _ = Describe("Dir", func() {
+	storeBehaviour(func() Store {
+		store, err := NewDir(filepath.Join(GinkgoT().TempDir(), "archive"))
+		Expect(err).NotTo(HaveOccurred())
+		return store
+	})
+
+	It("should not read outside the directory", func() {
+		dir := GinkgoT().TempDir()
+		Expect(os.WriteFile(filepath.Join(dir, "secret.json"), []byte("{}"), 0o600)).To(Succeed())
+		store, err := NewDir(filepath.Join(dir, "archive"))
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = store.Get(context.Background(), "../secret")
+		Expect(err).To(MatchError(ErrNotFound))
+	})
+})
+
+// newReviewingClient returns a fake client of the core types answering TokenReviews of a
+// user's name as token, and SubjectAccessReviews with the verbs allowed to each user
+func newReviewingClient(allowed map[string][]string) client.Client {
+	scheme := runtime.NewScheme()
+	Expect(corev1.AddToScheme(scheme)).To(Succeed())
+	mapper := meta.NewDefaultRESTMapper(nil)
+	mapper.Add(corev1.SchemeGroupVersion.WithKind("ConfigMap"), meta.RESTScopeNamespace)
+	mapper.Add(corev1.SchemeGroupVersion.WithKind("Secret"), meta.RESTScopeNamespace)
+	return fake.NewClientBuilder().WithScheme(scheme).WithRESTMapper(mapper).WithInterceptorFuncs(interceptor.Funcs{
+		Create: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.CreateOption) error {
+			switch review := obj.(type) {
+			case *authenticationv1.TokenReview:
+				_, known := allowed[review.Spec.Token]
+				review.Status.Authenticated = known
+				review.Status.User = authenticationv1.UserInfo{Username: review.Spec.Token}
+				return nil
+			case *authorizationv1.SubjectAccessReview:
+				review.Status.Allowed = slices.Contains(allowed[review.Spec.User], review.Spec.ResourceAttributes.Verb)
+				return nil
+			}
+			return c.Create(ctx, obj, opts...)
+		},
+	}).Build()
+}
+
+var _ = Describe("Objects", func() {
+	Context("of ConfigMaps", func() {
+		storeBehaviour(func() Store {
+			store, err := NewObjects(newFakeClient(), "kubettlreaper-system", false)
+			Expect(err).NotTo(HaveOccurred())
+			return store
+		})
+	})
+
+	Context("of Secrets", func() {
+		storeBehaviour(func() Store {
+			store, err := NewObjects(newFakeClient(), "kubettlreaper-system", true)
+			Expect(err).NotTo(HaveOccurred())
+			return store
+		})
+	})
+
+	It("should split a large snapshot across chunks", func() {
+		DeferCleanup(func(size int) { chunkSize = size }, chunkSize)
+		chunkSize = 256
+		ctx := context.Background()
+		c := newFakeClient()
+		store, err := NewObjects(c, "kubettlreaper-system", false)
+		Expect(err).NotTo(HaveOccurred())
+		configMap := &unstructured.Unstructured{}
+		configMap.SetAPIVersion("v1")
+		configMap.SetKind("ConfigMap")
+		configMap.SetNamespace("default")
+		configMap.SetName("tmp-ttl-fixtures")
+		configMap.SetUID("9012")
+		Expect(unstructured.SetNestedField(configMap.Object, strings.Repeat("x", 600), "data", "fixture")).To(Succeed())
+		Expect(store.Put(ctx, NewSnapshot(configMap))).To(Succeed())
+
+		first := &corev1.ConfigMap{}
+		Expect(c.Get(ctx, client.ObjectKey{Namespace: "kubettlreaper-system", Name: "kubettlreaper-archive-9012"}, first)).To(Succeed())
+		Expect(first.Annotations).To(HaveKeyWithValue(ChunksAnnotation, "4"))
+		Expect(c.Get(ctx, client.ObjectKey{Namespace: "kubettlreaper-system", Name: "kubettlreaper-archive-9012-3"},
+			&corev1.ConfigMap{})).To(Succeed())
+		got, err := store.Get(ctx, "9012")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(got.Object.Object).To(HaveKeyWithValue("data", HaveKeyWithValue("fixture", strings.Repeat("x", 600))))
+
+		pruned, err := store.Prune(ctx, time.Now().Add(time.Hour))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pruned).To(Equal(1))
+		list := &corev1.ConfigMapList{}
+		Expect(c.List(ctx, list)).To(Succeed())
+		Expect(list.Items).To(BeEmpty())
+	})
+
+	It("should keep the snapshots of Secrets in Secrets", func() {
+		ctx := context.Background()
+		c := newFakeClient()
+		store, err := NewObjects(c, "kubettlreaper-system", false)
+		Expect(err).NotTo(HaveOccurred())
+		secret := &unstructured.Unstructured{}
+		secret.SetAPIVersion("v1")
+		secret.SetKind("Secret")
+		secret.SetNamespace("default")
+		secret.SetName("tmp-token")
+		secret.SetUID("5678")
+		Expect(unstructured.SetNestedField(secret.Object, "c2VjcmV0", "data", "token")).To(Succeed())
+		Expect(store.Put(ctx, NewSnapshot(secret))).To(Succeed())
+
+		key := client.ObjectKey{Namespace: "kubettlreaper-system", Name: "kubettlreaper-archive-5678"}
+		Expect(apierrors.IsNotFound(c.Get(ctx, key, &corev1.ConfigMap{}))).To(BeTrue())
+		Expect(c.Get(ctx, key, &corev1.Secret{})).To(Succeed())
+		got, err := store.Get(ctx, "5678")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(got.Object.Object).To(HaveKey("data"))
+
+		pruned, err := store.Prune(ctx, time.Now().Add(time.Hour))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pruned).To(Equal(1))
+	})
+})
+
+var _ = Describe("Restore", func() {
+	var (
+		c     client.Client
+		store Store
+		ctx   = context.Background()
+		opts  = RestoreOptions{RemoveLabels: []string{"kubettlreaper.samir.io/ttl"}}
+	)
+
+	BeforeEach(func() {
+		c = newFakeClient()
+		var err error
+		store, err = NewDir(GinkgoT().TempDir())
+		Expect(err).NotTo(HaveOccurred())
+
+		// A ConfigMap stands in for the RoleBinding, the fake client only knows the core types
+		obj := roleBinding()
+		obj.SetAPIVersion("v1")
+		obj.SetKind("ConfigMap")
+		obj.Object["data"] = map[string]any{"key": "value"}
+		delete(obj.Object, "roleRef")
+		delete(obj.Object, "subjects")
+		Expect(store.Put(ctx, NewSnapshot(obj))).To(Succeed())
+	})
+
+	It("should create the archived object without its TTL", func() {
+		restored, err := Restore(ctx, c, store, "1234", opts)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(restored.GetUID()).NotTo(BeEquivalentTo("1234"))
+
+		configMap := &corev1.ConfigMap{}
+		Expect(c.Get(ctx, client.ObjectKey{Namespace: "ci", Name: "deployers"}, configMap)).To(Succeed())
+		Expect(configMap.Data).To(HaveKeyWithValue("key", "value"))
+		Expect(configMap.Labels).To(Equal(map[string]string{"team": "ci"}))
+
+		_, err = Restore(ctx, c, store, "1234", opts)
+		Expect(err).To(HaveOccurred())
+	})
+
+	Context("over HTTP", func() {
+		var server *httptest.Server
+
+		BeforeEach(func() {
+			c = newReviewingClient(map[string][]string{"restorer": {"get", "create"}, "viewer": {"get"}})
+			server = httptest.NewServer(Handler(c, store, opts))
+			DeferCleanup(server.Close)
+		})
+
+		// request makes a request as a user, none without a token
+		request := func(method, path, token string) *http.Response {
+			req, err := http.NewRequest(method, server.URL+path, nil)
+			Expect(err).NotTo(HaveOccurred())
+			if token != "" {
+				req.Header.Set("Authorization", "Bearer "+token)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			Expect(err).NotTo(HaveOccurred())
+			DeferCleanup(resp.Body.Close)
+			return resp
+		}
+
+		It("should serve the snapshots and restore them", func() {
+			resp := request(http.MethodGet, "/archive/1234", "viewer")
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			snapshot := Snapshot{}
+			Expect(json.NewDecoder(resp.Body).Decode(&snapshot)).To(Succeed())
+			Expect(snapshot.Name).To(Equal("deployers"))
+
+			Expect(request(http.MethodPost, "/archive/unknown/restore", "restorer").StatusCode).To(Equal(http.StatusNotFound))
+			Expect(request(http.MethodPost, "/archive/1234/restore", "restorer").StatusCode).To(Equal(http.StatusCreated))
+			Expect(c.Get(ctx, client.ObjectKey{Namespace: "ci", Name: "deployers"}, &corev1.ConfigMap{})).To(Succeed())
+			Expect(request(http.MethodPost, "/archive/1234/restore", "restorer").StatusCode).To(Equal(http.StatusConflict))
+		})
+
+		It("should refuse callers whose RBAC doesn't allow it", func() {
+			Expect(request(http.MethodGet, "/archive/1234", "").StatusCode).To(Equal(http.StatusUnauthorized))
+			Expect(request(http.MethodGet, "/archive/1234", "stranger").StatusCode).To(Equal(http.StatusUnauthorized))
+			Expect(request(http.MethodPost, "/archive/1234/restore", "viewer").StatusCode).To(Equal(http.StatusForbidden))
+			Expect(apierrors.IsNotFound(c.Get(ctx, client.ObjectKey{Namespace: "ci", Name: "deployers"}, &corev1.ConfigMap{}))).To(BeTrue())
+
+			// Listing the kind doesn't allow getting the object
+			server.Close()
+			server = httptest.NewServer(Handler(newReviewingClient(map[string][]string{"viewer": {"list"}}), store, opts))
+			DeferCleanup(server.Close)
+			Expect(request(http.MethodGet, "/archive/1234", "viewer").StatusCode).To(Equal(http.StatusForbidden))
+		})
+
+		It("should redact the data of Secrets", func() {
+			secret := &unstructured.Unstructured{}
+			secret.SetAPIVersion("v1")
+			secret.SetKind("Secret")
+			secret.SetNamespace("ci")
+			secret.SetName("deploy-token")
+			secret.SetUID("5678")
+			Expect(unstructured.SetNestedField(secret.Object, "c2VjcmV0", "data", "token")).To(Succeed())
+			Expect(store.Put(ctx, NewSnapshot(secret))).To(Succeed())
+
+			resp := request(http.MethodGet, "/archive/5678", "viewer")
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			snapshot := Snapshot{}
+			Expect(json.NewDecoder(resp.Body).Decode(&snapshot)).To(Succeed())
+			Expect(snapshot.Object.GetName()).To(Equal("deploy-token"))
+			Expect(snapshot.Object.Object).NotTo(HaveKey("data"))
+		})
+	})
+})
@@ -0,0 +1,118 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package archive
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// Dir archives snapshots as <uid>.json files in a directory, e.g. on a mounted volume
+type Dir struct {
+	dir string
+}
+
+// NewDir returns a store in a directory, creating it if needed
+func NewDir(dir string) (*Dir, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("no archive directory")
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return &Dir{dir: dir}, nil
+}
+
+func (d *Dir) path(uid types.UID) string {
+	return filepath.Join(d.dir, string(uid)+".json")
+}
+
+// Put writes the snapshot to a temporary file renamed into place, so a reader never sees part of it
+func (d *Dir) Put(_ context.Context, snapshot Snapshot) error {
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(d.dir, ".snapshot-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), d.path(snapshot.UID))
+}
+
+// Get reads the snapshot of a UID
+func (d *Dir) Get(_ context.Context, uid types.UID) (Snapshot, error) {
+	// UIDs come from users of the restore command, don't let one escape the directory
+	if strings.ContainsAny(string(uid), `/\`) || strings.HasPrefix(string(uid), ".") {
+		return Snapshot{}, ErrNotFound
+	}
+	data, err := os.ReadFile(d.path(uid))
+	if errors.Is(err, fs.ErrNotExist) {
+		return Snapshot{}, ErrNotFound
+	}
+	if err != nil {
+		return Snapshot{}, err
+	}
+	snapshot := Snapshot{}
+	return snapshot, json.Unmarshal(data, &snapshot)
+}
+
+// Prune deletes the files written before a time
+func (d *Dir) Prune(_ context.Context, before time.Time) (int, error) {
+	files, err := os.ReadDir(d.dir)
+	if err != nil {
+		return 0, err
+	}
+	pruned := 0
+	var errs []error
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+		info, err := file.Info()
+		if err != nil || !info.ModTime().Before(before) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(d.dir, file.Name())); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			errs = append(errs, err)
+			continue
+		}
+		pruned++
+	}
+	return pruned, errors.Join(errs...)
+}
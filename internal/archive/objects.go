@@ -0,0 +1,233 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package archive
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// ArchiveLabel marks the ConfigMaps and Secrets holding archived objects
+	ArchiveLabel = "kubettlreaper.samir.io/archive"
+	// ArchivedAtAnnotation records when the object was archived, for the retention period
+	ArchivedAtAnnotation = "kubettlreaper.samir.io/archived-at"
+	// ChunksAnnotation counts the ConfigMaps or Secrets a snapshot is split across, it is on the first
+	ChunksAnnotation = "kubettlreaper.samir.io/archive-chunks"
+	// ChunkAnnotation is the index of the chunks after the first
+	ChunkAnnotation = "kubettlreaper.samir.io/archive-chunk"
+	// snapshotKey is the data key of the snapshot
+	snapshotKey = "snapshot.json"
+)
+
+// chunkSize is the most snapshot data kept in a ConfigMap or Secret, below the 1MiB limit of
+// both with room for the metadata
+var chunkSize = 900 << 10
+
+// Objects archives snapshots as ConfigMaps or Secrets in a namespace, one per object named
+// after its UID. Snapshots of Secrets always go in Secrets so their data isn't kept in
+// plaintext ConfigMaps. Both are limited to about 1MiB, larger snapshots are split across
+// chunks named <name>-1, <name>-2...
+type Objects struct {
+	client    client.Client
+	namespace string
+	secrets   bool
+}
+
+// NewObjects returns a store of ConfigMaps, or Secrets, in a namespace
+func NewObjects(c client.Client, namespace string, secrets bool) (*Objects, error) {
+	if namespace == "" {
+		return nil, fmt.Errorf("no archive namespace")
+	}
+	return &Objects{client: c, namespace: namespace, secrets: secrets}, nil
+}
+
+func (o *Objects) key(uid types.UID) client.ObjectKey {
+	return client.ObjectKey{Namespace: o.namespace, Name: "kubettlreaper-archive-" + string(uid)}
+}
+
+// chunkKey returns the key of a chunk of a snapshot, the first is the key of the snapshot
+func (o *Objects) chunkKey(uid types.UID, i int) client.ObjectKey {
+	key := o.key(uid)
+	if i > 0 {
+		key.Name += "-" + strconv.Itoa(i)
+	}
+	return key
+}
+
+// newObject returns an empty Secret or ConfigMap
+func newObject(secret bool) client.Object {
+	if secret {
+		return &corev1.Secret{}
+	}
+	return &corev1.ConfigMap{}
+}
+
+// inSecret reports whether a snapshot is kept in a Secret
+func (o *Objects) inSecret(snapshot Snapshot) bool {
+	return o.secrets || snapshot.isSecret()
+}
+
+// Put creates the ConfigMaps or Secrets of a snapshot, or replaces them. The first chunk
+// is written last so a snapshot is only found once complete
+func (o *Objects) Put(ctx context.Context, snapshot Snapshot) error {
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	var chunks [][]byte
+	for len(data) > chunkSize {
+		chunks, data = append(chunks, data[:chunkSize]), data[chunkSize:]
+	}
+	chunks = append(chunks, data)
+
+	secret := o.inSecret(snapshot)
+	for i := len(chunks) - 1; i >= 0; i-- {
+		annotations := map[string]string{ArchivedAtAnnotation: snapshot.ArchivedAt.UTC().Format(time.RFC3339)}
+		if i == 0 {
+			annotations[ChunksAnnotation] = strconv.Itoa(len(chunks))
+		} else {
+			annotations[ChunkAnnotation] = strconv.Itoa(i)
+		}
+		meta := metav1.ObjectMeta{
+			Namespace:   o.namespace,
+			Name:        o.chunkKey(snapshot.UID, i).Name,
+			Labels:      map[string]string{ArchiveLabel: "true"},
+			Annotations: annotations,
+		}
+		if err := o.put(ctx, meta, secret, chunks[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// put creates the ConfigMap or Secret of a chunk, or replaces it
+func (o *Objects) put(ctx context.Context, meta metav1.ObjectMeta, secret bool, data []byte) error {
+	var obj client.Object
+	if secret {
+		obj = &corev1.Secret{ObjectMeta: meta, Data: map[string][]byte{snapshotKey: data}}
+	} else {
+		obj = &corev1.ConfigMap{ObjectMeta: meta, Data: map[string]string{snapshotKey: string(data)}}
+	}
+
+	err := o.client.Create(ctx, obj)
+	if !apierrors.IsAlreadyExists(err) {
+		return err
+	}
+	existing := newObject(secret)
+	if err := o.client.Get(ctx, client.ObjectKeyFromObject(obj), existing); err != nil {
+		return err
+	}
+	obj.SetResourceVersion(existing.GetResourceVersion())
+	return o.client.Update(ctx, obj)
+}
+
+// Get reads the snapshot of a UID, from ConfigMaps or else Secrets
+func (o *Objects) Get(ctx context.Context, uid types.UID) (Snapshot, error) {
+	obj := newObject(o.secrets)
+	err := o.client.Get(ctx, o.key(uid), obj)
+	if apierrors.IsNotFound(err) && !o.secrets {
+		obj = newObject(true)
+		err = o.client.Get(ctx, o.key(uid), obj)
+	}
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return Snapshot{}, ErrNotFound
+		}
+		return Snapshot{}, err
+	}
+
+	data := objectData(obj)
+	chunks, err := strconv.Atoi(cmp.Or(obj.GetAnnotations()[ChunksAnnotation], "1"))
+	if err != nil {
+		return Snapshot{}, fmt.Errorf("invalid %s annotation: %w", ChunksAnnotation, err)
+	}
+	_, secret := obj.(*corev1.Secret)
+	for i := 1; i < chunks; i++ {
+		chunk := newObject(secret)
+		if err := o.client.Get(ctx, o.chunkKey(uid, i), chunk); err != nil {
+			return Snapshot{}, fmt.Errorf("chunk %d of %d: %w", i, chunks, err)
+		}
+		data = append(data, objectData(chunk)...)
+	}
+	snapshot := Snapshot{}
+	return snapshot, json.Unmarshal(data, &snapshot)
+}
+
+// objectData returns the snapshot data of a ConfigMap or Secret
+func objectData(obj client.Object) []byte {
+	switch obj := obj.(type) {
+	case *corev1.Secret:
+		return obj.Data[snapshotKey]
+	case *corev1.ConfigMap:
+		return []byte(obj.Data[snapshotKey])
+	}
+	return nil
+}
+
+// Prune deletes the ConfigMaps and Secrets archived before a time, counting the snapshots
+func (o *Objects) Prune(ctx context.Context, before time.Time) (int, error) {
+	lists := []client.ObjectList{&corev1.SecretList{}}
+	if !o.secrets {
+		lists = append(lists, &corev1.ConfigMapList{})
+	}
+	var objs []client.Object
+	for _, list := range lists {
+		if err := o.client.List(ctx, list, client.InNamespace(o.namespace), client.MatchingLabels{ArchiveLabel: "true"}); err != nil {
+			return 0, err
+		}
+		switch list := list.(type) {
+		case *corev1.SecretList:
+			for i := range list.Items {
+				objs = append(objs, &list.Items[i])
+			}
+		case *corev1.ConfigMapList:
+			for i := range list.Items {
+				objs = append(objs, &list.Items[i])
+			}
+		}
+	}
+
+	pruned := 0
+	var errs []error
+	for _, obj := range objs {
+		archivedAt, err := time.Parse(time.RFC3339, obj.GetAnnotations()[ArchivedAtAnnotation])
+		if err != nil || !archivedAt.Before(before) {
+			continue
+		}
+		if err := o.client.Delete(ctx, obj); client.IgnoreNotFound(err) != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if _, chunk := obj.GetAnnotations()[ChunkAnnotation]; !chunk {
+			pruned++
+		}
+	}
+	return pruned, errors.Join(errs...)
+}
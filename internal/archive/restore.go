@@ -0,0 +1,160 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package archive
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// RestoreOptions remove the labels and annotations that would have a restored object
+// reaped again, e.g. its TTL
+type RestoreOptions struct {
+	RemoveLabels      []string
+	RemoveAnnotations []string
+}
+
+// Restore creates an archived object again, it gets a new UID. It fails when an object of
+// the same name exists
+func Restore(ctx context.Context, c client.Client, store Store, uid types.UID, opts RestoreOptions) (*unstructured.Unstructured, error) {
+	snapshot, err := store.Get(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+	return restore(ctx, c, snapshot, opts)
+}
+
+// restore creates the archived object of a snapshot again
+func restore(ctx context.Context, c client.Client, snapshot Snapshot, opts RestoreOptions) (*unstructured.Unstructured, error) {
+	if snapshot.Object == nil {
+		return nil, fmt.Errorf("archived snapshot of %s has no object", snapshot.UID)
+	}
+
+	obj := snapshot.Object.DeepCopy()
+	if labels := obj.GetLabels(); labels != nil {
+		for _, label := range opts.RemoveLabels {
+			delete(labels, label)
+		}
+		obj.SetLabels(labels)
+	}
+	if annotations := obj.GetAnnotations(); annotations != nil {
+		for _, annotation := range opts.RemoveAnnotations {
+			delete(annotations, annotation)
+		}
+		obj.SetAnnotations(annotations)
+	}
+	if err := c.Create(ctx, obj); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+// Handler serves the archive over HTTP, it is mounted on the secure metrics server at
+// /archive/ so requests are authorized against the nonResourceURLs of the caller's RBAC,
+// and then against the caller's RBAC on the archived object:
+//
+//	GET /archive/{uid}          returns the archived snapshot, a Secret's data is redacted,
+//	                            the caller needs get on the object
+//	POST /archive/{uid}/restore restores the object, the caller needs create on it
+func Handler(c client.Client, store Store, opts RestoreOptions) http.Handler {
+	reviewer := reviewer{client: c}
+	// snapshot returns the snapshot of a request once its caller is allowed the verb on it
+	snapshot := func(req *http.Request, verb string) (Snapshot, error) {
+		user, err := reviewer.authenticate(req)
+		if err != nil {
+			return Snapshot{}, err
+		}
+		snapshot, err := store.Get(req.Context(), types.UID(req.PathValue("uid")))
+		if err != nil {
+			return Snapshot{}, err
+		}
+		return snapshot, reviewer.authorize(req.Context(), user, verb, snapshot)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /archive/{uid}", func(w http.ResponseWriter, req *http.Request) {
+		snapshot, err := snapshot(req, "get")
+		if err != nil {
+			writeError(req.Context(), w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, redact(snapshot))
+	})
+	mux.HandleFunc("POST /archive/{uid}/restore", func(w http.ResponseWriter, req *http.Request) {
+		snapshot, err := snapshot(req, "create")
+		if err != nil {
+			writeError(req.Context(), w, err)
+			return
+		}
+		uid := snapshot.UID
+		obj, err := restore(req.Context(), c, snapshot, opts)
+		if err != nil {
+			writeError(req.Context(), w, err)
+			return
+		}
+		log.FromContext(req.Context()).Info("Restored archived object", "archivedUID", uid,
+			"kind", obj.GetKind(), "namespace", obj.GetNamespace(), "name", obj.GetName(), "uid", obj.GetUID())
+		writeJSON(w, http.StatusCreated, obj)
+	})
+	return mux
+}
+
+// redact drops the data of the snapshot of a Secret
+func redact(snapshot Snapshot) Snapshot {
+	if !snapshot.isSecret() || snapshot.Object == nil {
+		return snapshot
+	}
+	snapshot.Object = snapshot.Object.DeepCopy()
+	unstructured.RemoveNestedField(snapshot.Object.Object, "data")
+	unstructured.RemoveNestedField(snapshot.Object.Object, "stringData")
+	return snapshot
+}
+
+// writeError answers with the status of an error
+func writeError(ctx context.Context, w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, errUnauthenticated):
+		status = http.StatusUnauthorized
+	case errors.Is(err, errForbidden):
+		status = http.StatusForbidden
+	case errors.Is(err, ErrNotFound):
+		status = http.StatusNotFound
+	case apierrors.IsAlreadyExists(err):
+		status = http.StatusConflict
+	case apierrors.IsForbidden(err), apierrors.IsInvalid(err), apierrors.IsBadRequest(err):
+		status = http.StatusUnprocessableEntity
+	default:
+		log.FromContext(ctx).Error(err, "Archive request failed")
+	}
+	http.Error(w, err.Error(), status)
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,103 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package archive
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var (
+	// errUnauthenticated is returned for a request without a valid bearer token
+	errUnauthenticated = errors.New("unauthenticated")
+	// errForbidden is returned for a caller whose RBAC doesn't allow the request
+	errForbidden = errors.New("forbidden")
+)
+
+// reviewer authenticates the callers of the archive's HTTP API with TokenReviews, and
+// authorizes them with SubjectAccessReviews on the archived objects, so callers can only
+// read or restore the objects their RBAC allows rather than act with the operator's
+type reviewer struct {
+	client client.Client
+}
+
+// authenticate returns the user of the bearer token of a request
+func (r reviewer) authenticate(req *http.Request) (authenticationv1.UserInfo, error) {
+	token, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return authenticationv1.UserInfo{}, errUnauthenticated
+	}
+	review := &authenticationv1.TokenReview{Spec: authenticationv1.TokenReviewSpec{Token: token}}
+	if err := r.client.Create(req.Context(), review); err != nil {
+		return authenticationv1.UserInfo{}, fmt.Errorf("unable to review token: %w", err)
+	}
+	if !review.Status.Authenticated {
+		return authenticationv1.UserInfo{}, errUnauthenticated
+	}
+	return review.Status.User, nil
+}
+
+// authorize checks a user may get, or create, the archived object of a snapshot
+func (r reviewer) authorize(ctx context.Context, user authenticationv1.UserInfo, verb string, snapshot Snapshot) error {
+	gv, err := schema.ParseGroupVersion(snapshot.APIVersion)
+	if err != nil {
+		return err
+	}
+	mapping, err := r.client.RESTMapper().RESTMapping(gv.WithKind(snapshot.Kind).GroupKind(), gv.Version)
+	if err != nil {
+		return err
+	}
+
+	attributes := &authorizationv1.ResourceAttributes{
+		Namespace: snapshot.Namespace,
+		Verb:      verb,
+		Group:     gv.Group,
+		Version:   gv.Version,
+		Resource:  mapping.Resource.Resource,
+	}
+	// The API server doesn't authorize creates by name either
+	if verb != "create" {
+		attributes.Name = snapshot.Name
+	}
+	extra := map[string]authorizationv1.ExtraValue{}
+	for key, values := range user.Extra {
+		extra[key] = authorizationv1.ExtraValue(values)
+	}
+	review := &authorizationv1.SubjectAccessReview{Spec: authorizationv1.SubjectAccessReviewSpec{
+		ResourceAttributes: attributes,
+		User:               user.Username,
+		Groups:             user.Groups,
+		UID:                user.UID,
+		Extra:              extra,
+	}}
+	if err := r.client.Create(ctx, review); err != nil {
+		return fmt.Errorf("unable to review access: %w", err)
+	}
+	if !review.Status.Allowed {
+		return fmt.Errorf("%w: %s may not %s %s %s", errForbidden, user.Username, verb,
+			mapping.Resource.GroupResource(), client.ObjectKey{Namespace: snapshot.Namespace, Name: snapshot.Name})
+	}
+	return nil
+}
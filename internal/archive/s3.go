@@ -0,0 +1,292 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package archive
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// S3Options configure an S3-compatible store
+type S3Options struct {
+	// Endpoint of the S3 API, e.g. https://s3.eu-west-1.amazonaws.com or a MinIO URL,
+	// buckets are addressed path-style
+	Endpoint string
+	Bucket   string
+	// Prefix of the object keys, e.g. kubettlreaper/
+	Prefix string
+	// Region requests are signed for, us-east-1 when empty
+	Region          string
+	AccessKeyID     string
+	SecretAccessKey string
+	// SessionToken of temporary credentials, optional
+	SessionToken string
+	// Timeout of a request, 30s when zero
+	Timeout time.Duration
+}
+
+// S3 archives snapshots as <prefix><uid>.json objects of a bucket, requests are signed with
+// AWS Signature Version 4
+type S3 struct {
+	opts     S3Options
+	endpoint *url.URL
+	client   *http.Client
+}
+
+// NewS3 validates the options and returns an S3 store
+func NewS3(opts S3Options) (*S3, error) {
+	endpoint, err := url.Parse(opts.Endpoint)
+	if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid S3 endpoint %q", opts.Endpoint)
+	}
+	if opts.Bucket == "" {
+		return nil, fmt.Errorf("no S3 bucket")
+	}
+	if opts.AccessKeyID == "" || opts.SecretAccessKey == "" {
+		return nil, fmt.Errorf("no S3 credentials")
+	}
+	if opts.Region == "" {
+		opts.Region = "us-east-1"
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = 30 * time.Second
+	}
+	return &S3{opts: opts, endpoint: endpoint, client: &http.Client{Timeout: opts.Timeout}}, nil
+}
+
+func (s *S3) key(uid types.UID) string {
+	return s.opts.Prefix + string(uid) + ".json"
+}
+
+// Put uploads the snapshot
+func (s *S3) Put(ctx context.Context, snapshot Snapshot) error {
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	resp, err := s.do(ctx, http.MethodPut, s.key(snapshot.UID), nil, data)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Get downloads the snapshot of a UID
+func (s *S3) Get(ctx context.Context, uid types.UID) (Snapshot, error) {
+	if strings.Contains(string(uid), "/") {
+		return Snapshot{}, ErrNotFound
+	}
+	resp, err := s.do(ctx, http.MethodGet, s.key(uid), nil, nil)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	defer resp.Body.Close()
+	snapshot := Snapshot{}
+	return snapshot, json.NewDecoder(resp.Body).Decode(&snapshot)
+}
+
+// listResult is the response of ListObjectsV2
+type listResult struct {
+	Contents []struct {
+		Key          string    `xml:"Key"`
+		LastModified time.Time `xml:"LastModified"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// Prune deletes the objects under the prefix uploaded before a time
+func (s *S3) Prune(ctx context.Context, before time.Time) (int, error) {
+	pruned := 0
+	var errs []error
+	query := url.Values{"list-type": {"2"}, "prefix": {s.opts.Prefix}}
+	for {
+		resp, err := s.do(ctx, http.MethodGet, "", query, nil)
+		if err != nil {
+			return pruned, err
+		}
+		result := listResult{}
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return pruned, err
+		}
+
+		for _, object := range result.Contents {
+			if !strings.HasSuffix(object.Key, ".json") || !object.LastModified.Before(before) {
+				continue
+			}
+			resp, err := s.do(ctx, http.MethodDelete, object.Key, nil, nil)
+			if err != nil && !errors.Is(err, ErrNotFound) {
+				errs = append(errs, err)
+				continue
+			}
+			if resp != nil {
+				resp.Body.Close()
+			}
+			pruned++
+		}
+
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return pruned, errors.Join(errs...)
+		}
+		query.Set("continuation-token", result.NextContinuationToken)
+	}
+}
+
+// do sends a signed request for a key of the bucket, or the bucket itself when key is empty.
+// A 404 is ErrNotFound and other non 2xx responses are errors
+func (s *S3) do(ctx context.Context, method, key string, query url.Values, body []byte) (*http.Response, error) {
+	path := strings.TrimSuffix(s.endpoint.Path, "/") + "/" + s.opts.Bucket
+	if key != "" {
+		path += "/" + key
+	}
+	rawURL := s.endpoint.Scheme + "://" + s.endpoint.Host + uriEncode(path, false)
+	if len(query) > 0 {
+		rawURL += "?" + canonicalQuery(query)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, rawURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	signV4(req, body, s.opts.AccessKeyID, s.opts.SecretAccessKey, s.opts.SessionToken, s.opts.Region, time.Now())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound && key != "" {
+		return nil, ErrNotFound
+	}
+	message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	return nil, fmt.Errorf("S3 %s %s responded %d: %s", method, path, resp.StatusCode, bytes.TrimSpace(message))
+}
+
+// signedHeaderNames are the headers signed besides the host and the x-amz- headers, when set
+var signedHeaderNames = []string{"content-md5", "content-type", "date", "range"}
+
+// signV4 signs a request with AWS Signature Version 4 for the s3 service
+func signV4(req *http.Request, body []byte, accessKeyID, secretAccessKey, sessionToken, region string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	payloadHash := sha256Hex(body)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", sessionToken)
+	}
+
+	// The host, the x-amz- and the content headers are signed
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		if name = strings.ToLower(name); strings.HasPrefix(name, "x-amz-") || slices.Contains(signedHeaderNames, name) {
+			headers[name] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		uriEncode(req.URL.Path, false),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := date + "/" + region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+secretAccessKey), date)
+	for _, part := range []string{region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKeyID, scope, signedHeaders, signature))
+}
+
+// canonicalQuery encodes a query sorted by key, as signed
+func canonicalQuery(query url.Values) string {
+	pairs := make([]string, 0, len(query))
+	for key, values := range query {
+		for _, value := range values {
+			pairs = append(pairs, uriEncode(key, true)+"="+uriEncode(value, true))
+		}
+	}
+	slices.Sort(pairs)
+	return strings.Join(pairs, "&")
+}
+
+// uriEncode percent-encodes everything but the unreserved characters, and slashes unless encodeSlash
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '-', c == '.', c == '_', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,184 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audit
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// Outcomes of an audited action
+const (
+	OutcomeSucceeded = "succeeded"
+	OutcomeFailed    = "failed"
+	// OutcomeDryRun is an action that would have been taken but for dry run
+	OutcomeDryRun = "dry-run"
+)
+
+// ObjectReference identifies the object an action was taken on
+type ObjectReference struct {
+	APIVersion string    `json:"apiVersion"`
+	Kind       string    `json:"kind"`
+	Namespace  string    `json:"namespace,omitempty"`
+	Name       string    `json:"name"`
+	UID        types.UID `json:"uid"`
+}
+
+// Record is a line of the audit log about an action on an expired object. Hash chains the
+// records, it is the SHA-256 of the record with an empty Hash and PrevHash set to the
+// Hash of the record before it, so changing, removing or reordering records breaks the chain
+type Record struct {
+	Time   time.Time         `json:"time"`
+	Object ObjectReference   `json:"object"`
+	Labels map[string]string `json:"labels,omitempty"`
+	TTL    string            `json:"ttl,omitempty"`
+	// AnchorTime is the time the TTL counted down from, unset for an expires-at deadline
+	AnchorTime      *time.Time `json:"anchorTime,omitempty"`
+	ExpiresAt       time.Time  `json:"expiresAt"`
+	Action          string     `json:"action"`
+	Outcome         string     `json:"outcome"`
+	Error           string     `json:"error,omitempty"`
+	OperatorVersion string     `json:"operatorVersion"`
+	PrevHash        string     `json:"prevHash"`
+	Hash            string     `json:"hash"`
+}
+
+// hash returns the hash of a record, computed without its own Hash
+func (r Record) hash() (string, error) {
+	r.Hash = ""
+	line, err := json.Marshal(r)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(line)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Log appends hash chained records as JSON lines to a writer
+type Log struct {
+	mu       sync.Mutex
+	w        io.Writer
+	version  string
+	lastHash string
+	closer   io.Closer
+	// truncated is the size of an incomplete last record dropped when the file was opened
+	truncated int64
+}
+
+// New returns a log writing to w, the chain continues from lastHash, the Hash of the last
+// record already written, empty for a new log
+func New(w io.Writer, version, lastHash string) *Log {
+	return &Log{w: w, version: version, lastHash: lastHash}
+}
+
+// Close closes the file of the log, a log to stdout is left open
+func (l *Log) Close() error {
+	if l.closer == nil {
+		return nil
+	}
+	return l.closer.Close()
+}
+
+// Truncated returns the bytes of an incomplete last record dropped when the log was opened
+func (l *Log) Truncated() int64 {
+	return l.truncated
+}
+
+// Write chains a record to the log and appends it
+func (l *Log) Write(record Record) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	record.Time = record.Time.UTC()
+	record.ExpiresAt = record.ExpiresAt.UTC()
+	if record.AnchorTime != nil {
+		anchor := record.AnchorTime.UTC()
+		record.AnchorTime = &anchor
+	}
+	record.OperatorVersion = l.version
+	record.PrevHash = l.lastHash
+	hash, err := record.hash()
+	if err != nil {
+		return err
+	}
+	record.Hash = hash
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if _, err := l.w.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	l.lastHash = hash
+	return nil
+}
+
+// Verify checks the chain of the records read from r, starting from prevHash, and returns
+// the Hash of the last record. Rotated files are verified oldest first, each from the last
+// hash of the one before
+func Verify(r io.Reader, prevHash string) (string, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		record := Record{}
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return prevHash, fmt.Errorf("line %d: %w", lineNumber, err)
+		}
+		if record.PrevHash != prevHash {
+			return prevHash, fmt.Errorf("line %d: chain broken, previous hash %q, expected %q", lineNumber, record.PrevHash, prevHash)
+		}
+		hash, err := record.hash()
+		if err != nil {
+			return prevHash, fmt.Errorf("line %d: %w", lineNumber, err)
+		}
+		if hash != record.Hash {
+			return prevHash, fmt.Errorf("line %d: record was modified, hash %q, expected %q", lineNumber, record.Hash, hash)
+		}
+		prevHash = hash
+	}
+	return prevHash, scanner.Err()
+}
+
+// lastHash returns the Hash of the last record read from r, without verifying the chain
+func lastHash(r io.Reader) (string, error) {
+	var last string
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		record := Record{}
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return "", err
+		}
+		last = record.Hash
+	}
+	return last, scanner.Err()
+}
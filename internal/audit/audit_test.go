@@ -0,0 +1,197 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audit
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"k8s.io/utils/ptr"
+)
+
+// record returns a record of a pod deleted on expiry
+func record(name string) Record {
+	expiresAt := time.Date(2024, 11, 1, 18, 0, 0, 0, time.UTC)
+	return Record{
+		Time:       expiresAt.Add(5 * time.Second),
+		Object:     ObjectReference{APIVersion: "v1", Kind: "Pod", Namespace: "ci", Name: name, UID: "1234"},
+		Labels:     map[string]string{"kubettlreaper.samir.io/ttl": "1h", "app": "runner"},
+		TTL:        "1h",
+		AnchorTime: ptr.To(expiresAt.Add(-time.Hour)),
+		ExpiresAt:  expiresAt,
+		Action:     "Delete",
+		Outcome:    OutcomeSucceeded,
+	}
+}
+
+var _ = Describe("Log", func() {
+	It("should write chained JSON lines that verify", func() {
+		buf := &bytes.Buffer{}
+		log := New(buf, "v1.2.0", "")
+		for _, name := range []string{"a", "b", "c"} {
+			Expect(log.Write(record(name))).To(Succeed())
+		}
+
+		lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+		Expect(lines).To(HaveLen(3))
+		Expect(lines[0]).To(ContainSubstring(`"prevHash":""`))
+		Expect(lines[0]).To(ContainSubstring(`"operatorVersion":"v1.2.0"`))
+		Expect(lines[0]).To(ContainSubstring(`"anchorTime":"2024-11-01T17:00:00Z"`))
+
+		last, err := Verify(strings.NewReader(buf.String()), "")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(lines[2]).To(ContainSubstring(last))
+	})
+
+	DescribeTable("should detect tampering",
+		func(tamper func(lines []string) []string, expected string) {
+			buf := &bytes.Buffer{}
+			log := New(buf, "v1.2.0", "")
+			for _, name := range []string{"a", "b", "c"} {
+				Expect(log.Write(record(name))).To(Succeed())
+			}
+
+			lines := tamper(strings.Split(strings.TrimSpace(buf.String()), "\n"))
+			_, err := Verify(strings.NewReader(strings.Join(lines, "\n")), "")
+			Expect(err).To(MatchError(ContainSubstring(expected)))
+		},
+		Entry("a modified record", func(lines []string) []string {
+			lines[1] = strings.Replace(lines[1], `"outcome":"succeeded"`, `"outcome":"failed"`, 1)
+			return lines
+		}, "line 2: record was modified"),
+		Entry("a removed record", func(lines []string) []string {
+			return append(lines[:1], lines[2:]...)
+		}, "line 2: chain broken"),
+		Entry("reordered records", func(lines []string) []string {
+			lines[0], lines[1] = lines[1], lines[0]
+			return lines
+		}, "line 1: chain broken"),
+	)
+})
+
+var _ = Describe("File", func() {
+	var path string
+
+	BeforeEach(func() {
+		path = filepath.Join(GinkgoT().TempDir(), "audit.log")
+	})
+
+	It("should rotate, keep the backups and verify across the files", func() {
+		log, err := Open(path, "v1.2.0", 600, 2)
+		Expect(err).NotTo(HaveOccurred())
+		for range 4 {
+			Expect(log.Write(record("tmp-ttl-runner"))).To(Succeed())
+		}
+		Expect(log.Close()).To(Succeed())
+
+		Expect(Files(path)).To(Equal([]string{path + ".2", path + ".1", path}))
+		records, lastHash, err := VerifyFiles(path)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(records).To(Equal(3))
+		Expect(lastHashOf(path)).To(Equal(lastHash))
+	})
+
+	It("should refuse to rotate without backups", func() {
+		_, err := Open(path, "v1.2.0", 600, 0)
+		Expect(err).To(MatchError(ContainSubstring("at least one backup")))
+		Expect(Files(path)).To(BeEmpty())
+	})
+
+	It("should detect records missing at the head from the chain anchor", func() {
+		log, err := Open(path, "v1.2.0", 600, 2)
+		Expect(err).NotTo(HaveOccurred())
+		for range 4 {
+			Expect(log.Write(record("tmp-ttl-runner"))).To(Succeed())
+		}
+		Expect(log.Close()).To(Succeed())
+
+		By("dropping the oldest rotated file")
+		Expect(os.Rename(path+".2", path+".dropped")).To(Succeed())
+		_, _, err = VerifyFiles(path)
+		Expect(err).To(MatchError(ContainSubstring("chain broken")))
+		Expect(os.Rename(path+".dropped", path+".2")).To(Succeed())
+
+		By("requiring the anchor")
+		Expect(os.Remove(AnchorName(path))).To(Succeed())
+		_, _, err = VerifyFiles(path)
+		Expect(err).To(MatchError(ContainSubstring("chain anchor")))
+	})
+
+	It("should anchor a new log at the genesis", func() {
+		log, err := Open(path, "v1.2.0", 0, 0)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(log.Write(record("a"))).To(Succeed())
+		Expect(log.Write(record("b"))).To(Succeed())
+		Expect(log.Close()).To(Succeed())
+
+		content, err := os.ReadFile(path)
+		Expect(err).NotTo(HaveOccurred())
+		lines := strings.SplitAfter(string(content), "\n")
+		Expect(os.WriteFile(path, []byte(lines[1]), 0o600)).To(Succeed())
+		_, _, err = VerifyFiles(path)
+		Expect(err).To(MatchError(ContainSubstring("line 1: chain broken")))
+	})
+
+	It("should drop an incomplete last record left by a crash", func() {
+		log, err := Open(path, "v1.2.0", 0, 0)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(log.Write(record("a"))).To(Succeed())
+		Expect(log.Close()).To(Succeed())
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = file.WriteString(`{"time":"2024-11-01T18:00:05Z","object":{"api`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(file.Close()).To(Succeed())
+
+		log, err = Open(path, "v1.2.0", 0, 0)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(log.Truncated()).To(BeEquivalentTo(45))
+		Expect(log.Write(record("b"))).To(Succeed())
+		Expect(log.Close()).To(Succeed())
+
+		records, _, err := VerifyFiles(path)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(records).To(Equal(2))
+	})
+
+	It("should continue the chain of an existing log", func() {
+		log, err := Open(path, "v1.2.0", 0, 0)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(log.Write(record("a"))).To(Succeed())
+		Expect(log.Close()).To(Succeed())
+
+		log, err = Open(path, "v1.3.0", 0, 0)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(log.Write(record("b"))).To(Succeed())
+		Expect(log.Close()).To(Succeed())
+
+		records, _, err := VerifyFiles(path)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(records).To(Equal(2))
+
+		content, err := os.ReadFile(path)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(os.WriteFile(path, bytes.Replace(content, []byte(`"name":"a"`), []byte(`"name":"x"`), 1), 0o600)).To(Succeed())
+		_, _, err = VerifyFiles(path)
+		Expect(err).To(MatchError(ContainSubstring("record was modified")))
+	})
+})
@@ -0,0 +1,298 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audit
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Stdout is the destination of a log written to stdout
+const Stdout = "-"
+
+// File is an audit log file rotated when it would grow beyond a maximum size, rotated files
+// are kept as <path>.1, the newest, to <path>.<maxBackups>
+type File struct {
+	mu         sync.Mutex
+	path       string
+	maxSize    int64
+	maxBackups int
+	file       *os.File
+	size       int64
+}
+
+// OpenFile opens an audit log file for appending, a maxSize of 0 never rotates it, otherwise
+// at least one rotated file is kept so rotating never discards the records just written.
+// A new log gets the genesis chain anchor
+func OpenFile(path string, maxSize int64, maxBackups int) (*File, error) {
+	if maxSize > 0 && maxBackups < 1 {
+		return nil, fmt.Errorf("a rotated audit log needs at least one backup, got %d", maxBackups)
+	}
+	f := &File{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if len(Files(path)) == 0 {
+		if err := writeAnchor(path, ""); err != nil {
+			return nil, err
+		}
+	}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *File) open() error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file, f.size = file, info.Size()
+	return nil
+}
+
+// Write appends p, a whole record, rotating the file first when p would not fit
+func (f *File) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.maxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.maxSize {
+		if err := f.rotate(); err != nil {
+			return 0, fmt.Errorf("rotating %s: %w", f.path, err)
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	if err != nil {
+		return n, err
+	}
+	return n, f.file.Sync()
+}
+
+// rotate shifts the rotated files up by one, dropping the oldest, and starts a new file.
+// The chain anchor moves to the last hash of the file dropped
+func (f *File) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	if err := f.dropFile(backupName(f.path, f.maxBackups)); err != nil {
+		return err
+	}
+	for i := f.maxBackups - 1; i >= 1; i-- {
+		if err := os.Rename(backupName(f.path, i), backupName(f.path, i+1)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	if err := os.Rename(f.path, backupName(f.path, 1)); err != nil {
+		return err
+	}
+	return f.open()
+}
+
+// dropFile removes a file of the log after moving the chain anchor to its last hash
+func (f *File) dropFile(name string) error {
+	last, err := lastHashOf(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := writeAnchor(f.path, last); err != nil {
+		return err
+	}
+	return os.Remove(name)
+}
+
+// Close closes the file
+func (f *File) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.file.Close()
+}
+
+func backupName(path string, i int) string {
+	return fmt.Sprintf("%s.%d", path, i)
+}
+
+// AnchorName returns the file keeping the chain anchor of an audit log, the PrevHash of its
+// oldest record kept: empty for a new log, the last hash of the newest file dropped once rotated
+func AnchorName(path string) string {
+	return path + ".anchor"
+}
+
+func readAnchor(path string) (string, error) {
+	content, err := os.ReadFile(AnchorName(path))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(content)), nil
+}
+
+// writeAnchor replaces the chain anchor, through a rename so it is never half written
+func writeAnchor(path, hash string) error {
+	tmp := AnchorName(path) + ".tmp"
+	if err := os.WriteFile(tmp, []byte(hash+"\n"), 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, AnchorName(path))
+}
+
+// Files returns the existing files of an audit log, oldest first
+func Files(path string) []string {
+	var files []string
+	for i := 1; ; i++ {
+		if _, err := os.Stat(backupName(path, i)); err != nil {
+			break
+		}
+		files = append([]string{backupName(path, i)}, files...)
+	}
+	if _, err := os.Stat(path); err == nil {
+		files = append(files, path)
+	}
+	return files
+}
+
+// Open opens the audit log at dest, Stdout or a file path. The chain of an existing file
+// continues from its last record, an incomplete last record left by a crash while it was
+// written is dropped, see Truncated
+func Open(dest, version string, maxSize int64, maxBackups int) (*Log, error) {
+	if dest == Stdout {
+		return New(os.Stdout, version, ""), nil
+	}
+
+	truncated, err := truncatePartial(dest)
+	if err != nil {
+		return nil, fmt.Errorf("dropping the incomplete last record of %s: %w", dest, err)
+	}
+	files := Files(dest)
+	var last string
+	for i := len(files) - 1; i >= 0 && last == ""; i-- {
+		hash, err := lastHashOf(files[i])
+		if err != nil {
+			return nil, fmt.Errorf("reading the last record of %s: %w", files[i], err)
+		}
+		last = hash
+	}
+	file, err := OpenFile(dest, maxSize, maxBackups)
+	if err != nil {
+		return nil, err
+	}
+	log := New(file, version, last)
+	log.closer, log.truncated = file, truncated
+	return log, nil
+}
+
+// truncatePartial drops the bytes after the last newline of a file, a record whose write
+// didn't complete, and returns how many were dropped
+func truncatePartial(path string) (int64, error) {
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	// Read backwards to the last newline
+	size, end := info.Size(), info.Size()
+	buf := make([]byte, 4096)
+	for end > 0 {
+		n := min(int64(len(buf)), end)
+		if _, err := file.ReadAt(buf[:n], end-n); err != nil {
+			return 0, err
+		}
+		if i := bytes.LastIndexByte(buf[:n], '\n'); i >= 0 {
+			end += int64(i) + 1 - n
+			break
+		}
+		end -= n
+	}
+	if end == size {
+		return 0, nil
+	}
+	return size - end, file.Truncate(end)
+}
+
+func lastHashOf(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	return lastHash(file)
+}
+
+// VerifyFiles verifies the chain across the files of an audit log, oldest first, from its
+// chain anchor so records missing at the head break the chain. It returns the number of
+// records and the hash of the last one, which callers compare with a trusted copy, e.g.
+// shipped elsewhere, to tell records are missing at the tail
+func VerifyFiles(path string) (int, string, error) {
+	files := Files(path)
+	if len(files) == 0 {
+		return 0, "", fmt.Errorf("no audit log at %s", path)
+	}
+	prevHash, err := readAnchor(path)
+	if err != nil {
+		return 0, "", fmt.Errorf("reading the chain anchor: %w", err)
+	}
+
+	records := 0
+	for _, name := range files {
+		file, err := os.Open(name)
+		if err != nil {
+			return records, prevHash, err
+		}
+		counter := &lineCounter{r: file}
+		prevHash, err = Verify(counter, prevHash)
+		file.Close()
+		if err != nil {
+			return records, prevHash, fmt.Errorf("%s: %w", name, err)
+		}
+		records += counter.lines
+	}
+	return records, prevHash, nil
+}
+
+// lineCounter counts the records read through it
+type lineCounter struct {
+	r     io.Reader
+	lines int
+}
+
+func (c *lineCounter) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	for _, b := range p[:n] {
+		if b == '\n' {
+			c.lines++
+		}
+	}
+	return n, err
+}
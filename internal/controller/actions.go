@@ -0,0 +1,293 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"kubettlreaper/api/v1alpha1"
+)
+
+const (
+	// ActionAnnotation overrides the action of the kind for an object, e.g. scale-to-zero
+	ActionAnnotation = "kubettlreaper.samir.io/action"
+	// ActionedAtAnnotation records when an action that keeps the object was taken, so it
+	// isn't taken again until the object expires again
+	ActionedAtAnnotation = "kubettlreaper.samir.io/actioned-at"
+	// ExpiredLabel is added by the label action
+	ExpiredLabel = "kubettlreaper.samir.io/expired"
+	// FieldOwner is the field manager of the reaper's writes to the objects it reaps, they don't
+	// count as updates for the last-update TTL start so they don't push the expiry out
+	FieldOwner = "kubettlreaper"
+)
+
+// Actions of the configMap and the action annotation
+const (
+	ActionDelete      = "delete"
+	ActionScaleToZero = "scale-to-zero"
+	ActionSuspend     = "suspend"
+	ActionPatch       = "patch"
+	ActionLabel       = "label"
+	ActionEvict       = "evict"
+)
+
+// configMap and annotation actions and their TtlReaperPolicy equivalent
+var configMapActions = map[string]v1alpha1.ExpiryAction{
+	ActionDelete:      v1alpha1.ActionDelete,
+	ActionScaleToZero: v1alpha1.ActionScaleToZero,
+	ActionSuspend:     v1alpha1.ActionSuspend,
+	ActionPatch:       v1alpha1.ActionPatch,
+	ActionLabel:       v1alpha1.ActionLabel,
+	ActionEvict:       v1alpha1.ActionEvict,
+}
+
+// actionEvents are the event reasons and messages of the actions other than delete
+var actionEvents = map[v1alpha1.ExpiryAction]struct{ reason, message string }{
+	v1alpha1.ActionScaleToZero: {"ScaledToZero", "Scaled to zero due to expired TTL"},
+	v1alpha1.ActionSuspend:     {"Suspended", "Suspended due to expired TTL"},
+	v1alpha1.ActionPatch:       {"Patched", "Patched due to expired TTL"},
+	v1alpha1.ActionLabel:       {"LabelledExpired", "Labelled expired due to expired TTL"},
+	v1alpha1.ActionEvict:       {"Evicted", "Evicted due to expired TTL"},
+}
+
+// podGVK is the only kind that can be evicted
+var podGVK = schema.GroupVersionKind{Version: "v1", Kind: "Pod"}
+
+// convertAction converts a configMap or annotation action to a policy action
+func convertAction(action string) (v1alpha1.ExpiryAction, error) {
+	converted, ok := configMapActions[action]
+	if !ok {
+		return "", fmt.Errorf("invalid action %q, expected one of %s, %s, %s, %s, %s or %s", action,
+			ActionDelete, ActionScaleToZero, ActionSuspend, ActionPatch, ActionLabel, ActionEvict)
+	}
+
+	return converted, nil
+}
+
+// removes reports whether an action removes the object
+func removes(action v1alpha1.ExpiryAction) bool {
+	return action == v1alpha1.ActionDelete || action == v1alpha1.ActionEvict
+}
+
+// newActionPatch validates the action of a kind and returns the patch of the patch action
+func newActionPatch(gvk schema.GroupVersionKind, action v1alpha1.ExpiryAction, patch string, patchType v1alpha1.PatchType) (client.Patch, error) {
+	switch action {
+	case "", v1alpha1.ActionDelete, v1alpha1.ActionScaleToZero, v1alpha1.ActionSuspend, v1alpha1.ActionLabel:
+	case v1alpha1.ActionEvict:
+		if gvk != podGVK {
+			return nil, fmt.Errorf("only Pods can be evicted")
+		}
+	case v1alpha1.ActionPatch:
+	default:
+		return nil, fmt.Errorf("invalid action %q", action)
+	}
+	if patch == "" {
+		if action == v1alpha1.ActionPatch {
+			return nil, fmt.Errorf("the %s action requires a patch", action)
+		}
+		return nil, nil
+	}
+
+	if !json.Valid([]byte(patch)) {
+		return nil, fmt.Errorf("invalid patch: not JSON")
+	}
+	switch patchType {
+	case "", v1alpha1.PatchMerge:
+		return client.RawPatch(types.MergePatchType, []byte(patch)), nil
+	case v1alpha1.PatchJSON:
+		if !strings.HasPrefix(strings.TrimSpace(patch), "[") {
+			return nil, fmt.Errorf("invalid patch: a JSON patch is a list of operations")
+		}
+		return client.RawPatch(types.JSONPatchType, []byte(patch)), nil
+	}
+
+	return nil, fmt.Errorf("invalid patch type %q, expected one of %s or %s", patchType, v1alpha1.PatchJSON, v1alpha1.PatchMerge)
+}
+
+// crdGVK is the kind of CustomResourceDefinitions, read to tell whether a custom kind has spec.suspend
+var crdGVK = schema.GroupVersionKind{Group: "apiextensions.k8s.io", Version: "v1", Kind: "CustomResourceDefinition"}
+
+// validateActions checks the kinds of a configuration support their action, looking them up
+// with discovery. Kinds that can't be looked up, e.g. not served yet, are checked on a later
+// reconcile
+func (r *TtlReaperReconciler) validateActions(ctx context.Context, config *reaperConfig) error {
+	if r.discovery == nil {
+		return nil
+	}
+	for i, rule := range config.rules {
+		if rule.action != v1alpha1.ActionScaleToZero && rule.action != v1alpha1.ActionSuspend {
+			continue
+		}
+		supported, err := r.supportsAction(ctx, rule.GroupVersionKind(), rule.action)
+		if err != nil {
+			log.FromContext(ctx).Info("Unable to check the action of a kind", "gvk", rule.String(), "reason", err.Error())
+			continue
+		}
+		if !supported && rule.action == v1alpha1.ActionScaleToZero {
+			return fmt.Errorf("kind %d (%s): the %s action needs the scale subresource", i, rule, rule.action)
+		}
+		if !supported {
+			return fmt.Errorf("kind %d (%s): the %s action needs spec.suspend", i, rule, rule.action)
+		}
+	}
+	return nil
+}
+
+// supportsAction reports whether a kind supports an action: scale-to-zero needs the scale
+// subresource, suspend spec.suspend, which Jobs, CronJobs and custom kinds whose schema
+// has it have
+func (r *TtlReaperReconciler) supportsAction(ctx context.Context, gvk schema.GroupVersionKind,
+	action v1alpha1.ExpiryAction) (bool, error) {
+	if action == v1alpha1.ActionSuspend && gvk.Group == "batch" && (gvk.Kind == "Job" || gvk.Kind == "CronJob") {
+		return true, nil
+	}
+	resources, err := r.discovery.ServerResourcesForGroupVersion(gvk.GroupVersion().String())
+	if err != nil {
+		return false, err
+	}
+	var plural string
+	for _, resource := range resources.APIResources {
+		if resource.Kind == gvk.Kind && !strings.Contains(resource.Name, "/") {
+			plural = resource.Name
+		}
+	}
+	if plural == "" {
+		return false, fmt.Errorf("%s isn't served", gvk)
+	}
+	if action == v1alpha1.ActionScaleToZero {
+		return slices.ContainsFunc(resources.APIResources, func(resource metav1.APIResource) bool {
+			return resource.Name == plural+"/scale"
+		}), nil
+	}
+
+	// Other built-in kinds have no spec.suspend, custom kinds have it when their schema does
+	crd := &unstructured.Unstructured{}
+	crd.SetGroupVersionKind(crdGVK)
+	err = r.apiReader.Get(ctx, client.ObjectKey{Name: plural + "." + gvk.Group}, crd)
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	versions, _, _ := unstructured.NestedSlice(crd.Object, "spec", "versions")
+	for _, version := range versions {
+		version, _ := version.(map[string]any)
+		if version["name"] != gvk.Version {
+			continue
+		}
+		spec, _, _ := unstructured.NestedMap(version, "schema", "openAPIV3Schema", "properties", "spec")
+		_, suspend, _ := unstructured.NestedMap(spec, "properties", "suspend")
+		preserveUnknown, _, _ := unstructured.NestedBool(spec, "x-kubernetes-preserve-unknown-fields")
+		return suspend || preserveUnknown, nil
+	}
+	return false, nil
+}
+
+// objectAction returns the action to take on an expired object, the action annotation can
+// pick another action than the kind's but not one removing the object when the kind's doesn't
+func objectAction(obj client.Object, rule gvkRule) (v1alpha1.ExpiryAction, error) {
+	annotation, exists := obj.GetAnnotations()[ActionAnnotation]
+	if !exists {
+		return rule.action, nil
+	}
+
+	action, err := convertAction(annotation)
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case removes(action) && !removes(rule.action):
+		return "", fmt.Errorf("action %q can't remove an object the %s action of its kind keeps", annotation, rule.action)
+	case action == v1alpha1.ActionEvict && rule.GroupVersionKind() != podGVK:
+		return "", fmt.Errorf("action %q only applies to Pods", annotation)
+	case action == v1alpha1.ActionPatch && rule.patch == nil:
+		return "", fmt.Errorf("action %q needs a patch configured for the kind", annotation)
+	}
+
+	return action, nil
+}
+
+// actioned reports whether an action keeping the object was already taken since it expired
+func actioned(obj client.Object, expirationTime time.Time) bool {
+	actionedAt, err := time.Parse(time.RFC3339, obj.GetAnnotations()[ActionedAtAnnotation])
+	return err == nil && !actionedAt.Before(expirationTime.Truncate(time.Second))
+}
+
+// takeAction takes an action other than delete on an expired object, then records it
+// on the object when the object is kept
+func (r *TtlReaperReconciler) takeAction(ctx context.Context, obj client.Object, rule gvkRule,
+	action v1alpha1.ExpiryAction, dryRun bool) error {
+	var (
+		patchOpts    = []client.PatchOption{client.FieldOwner(FieldOwner)}
+		subPatchOpts = []client.SubResourcePatchOption{client.FieldOwner(FieldOwner)}
+		createOpts   []client.SubResourceCreateOption
+	)
+	if dryRun {
+		patchOpts = append(patchOpts, client.DryRunAll)
+		subPatchOpts = append(subPatchOpts, client.DryRunAll)
+		createOpts = append(createOpts, client.DryRunAll)
+	}
+
+	// Patch a bare object of the kind so only the patch is sent
+	target := &unstructured.Unstructured{}
+	target.SetGroupVersionKind(rule.GroupVersionKind())
+	target.SetNamespace(obj.GetNamespace())
+	target.SetName(obj.GetName())
+
+	var err error
+	switch action {
+	case v1alpha1.ActionScaleToZero:
+		err = r.SubResource("scale").Patch(ctx, target,
+			client.RawPatch(types.MergePatchType, []byte(`{"spec":{"replicas":0}}`)), subPatchOpts...)
+	case v1alpha1.ActionSuspend:
+		err = r.Patch(ctx, target, client.RawPatch(types.MergePatchType, []byte(`{"spec":{"suspend":true}}`)), patchOpts...)
+	case v1alpha1.ActionPatch:
+		err = r.Patch(ctx, target, rule.patch, patchOpts...)
+	case v1alpha1.ActionLabel:
+		err = r.Patch(ctx, target, client.RawPatch(types.MergePatchType,
+			[]byte(fmt.Sprintf(`{"metadata":{"labels":{%q:"true"}}}`, ExpiredLabel))), patchOpts...)
+	case v1alpha1.ActionEvict:
+		pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: obj.GetNamespace(), Name: obj.GetName()}}
+		eviction := &policyv1.Eviction{ObjectMeta: metav1.ObjectMeta{Namespace: obj.GetNamespace(), Name: obj.GetName()}}
+		return r.SubResource("eviction").Create(ctx, pod, eviction, createOpts...)
+	default:
+		return fmt.Errorf("unsupported action %q", action)
+	}
+	if err != nil || dryRun {
+		return err
+	}
+
+	// Record the action so it isn't taken again on every sweep
+	return r.Patch(ctx, target, client.RawPatch(types.MergePatchType, []byte(fmt.Sprintf(
+		`{"metadata":{"annotations":{%q:%q}}}`, ActionedAtAnnotation, time.Now().UTC().Format(time.RFC3339)))), patchOpts...)
+}
@@ -0,0 +1,173 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	clienttesting "k8s.io/client-go/testing"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"kubettlreaper/api/v1alpha1"
+)
+
+var _ = Describe("Actions", func() {
+	deploymentGVK := schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"}
+
+	It("should validate the action and patch of a kind", func() {
+		patch, err := newActionPatch(deploymentGVK, v1alpha1.ActionScaleToZero, "", "")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(patch).To(BeNil())
+
+		_, err = newActionPatch(deploymentGVK, v1alpha1.ActionEvict, "", "")
+		Expect(err).To(HaveOccurred())
+		_, err = newActionPatch(podGVK, v1alpha1.ActionEvict, "", "")
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = newActionPatch(deploymentGVK, v1alpha1.ActionPatch, "", "")
+		Expect(err).To(HaveOccurred())
+		_, err = newActionPatch(deploymentGVK, v1alpha1.ActionPatch, `{"spec":`, "")
+		Expect(err).To(HaveOccurred())
+		_, err = newActionPatch(deploymentGVK, v1alpha1.ActionPatch, `{"spec":{"paused":true}}`, v1alpha1.PatchJSON)
+		Expect(err).To(HaveOccurred())
+
+		patch, err = newActionPatch(deploymentGVK, v1alpha1.ActionPatch, `{"spec":{"paused":true}}`, "")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(patch.Type()).To(Equal(types.MergePatchType))
+
+		patch, err = newActionPatch(deploymentGVK, v1alpha1.ActionPatch,
+			`[{"op":"replace","path":"/spec/paused","value":true}]`, v1alpha1.PatchJSON)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(patch.Type()).To(Equal(types.JSONPatchType))
+	})
+
+	It("should check a kind supports scale-to-zero or suspend", func() {
+		crd := &unstructured.Unstructured{Object: map[string]any{
+			"metadata": map[string]any{"name": "previews.example.com"},
+			"spec": map[string]any{"versions": []any{map[string]any{
+				"name": "v1",
+				"schema": map[string]any{"openAPIV3Schema": map[string]any{"properties": map[string]any{
+					"spec": map[string]any{"properties": map[string]any{"suspend": map[string]any{"type": "boolean"}}},
+				}}},
+			}}},
+		}}
+		crd.SetGroupVersionKind(crdGVK)
+		c := fake.NewClientBuilder().WithObjects(crd).Build()
+		discoveryClient := &fakediscovery.FakeDiscovery{Fake: &clienttesting.Fake{Resources: []*metav1.APIResourceList{{
+			GroupVersion: "apps/v1",
+			APIResources: []metav1.APIResource{
+				{Name: "deployments", Kind: "Deployment"},
+				{Name: "deployments/scale", Kind: "Scale"},
+				{Name: "controllerrevisions", Kind: "ControllerRevision"},
+			},
+		}, {
+			GroupVersion: "example.com/v1",
+			APIResources: []metav1.APIResource{{Name: "previews", Kind: "Preview"}, {Name: "sandboxes", Kind: "Sandbox"}},
+		}}}}
+		reconciler := &TtlReaperReconciler{apiReader: c, discovery: discoveryClient}
+
+		validate := func(group, kind string, action v1alpha1.ExpiryAction) error {
+			return reconciler.validateActions(ctx, &reaperConfig{rules: []gvkRule{
+				{Group: group, Version: "v1", Kind: kind, action: action},
+			}})
+		}
+		Expect(validate("apps", "Deployment", v1alpha1.ActionScaleToZero)).To(Succeed())
+		Expect(validate("apps", "ControllerRevision", v1alpha1.ActionScaleToZero)).To(
+			MatchError(ContainSubstring("needs the scale subresource")))
+		Expect(validate("batch", "CronJob", v1alpha1.ActionSuspend)).To(Succeed())
+		Expect(validate("example.com", "Preview", v1alpha1.ActionSuspend)).To(Succeed())
+		Expect(validate("example.com", "Sandbox", v1alpha1.ActionSuspend)).To(MatchError(ContainSubstring("needs spec.suspend")))
+		Expect(validate("apps", "Deployment", v1alpha1.ActionSuspend)).To(MatchError(ContainSubstring("needs spec.suspend")))
+		// Kinds that aren't served are checked once they are
+		Expect(validate("example.com", "Unknown", v1alpha1.ActionScaleToZero)).To(Succeed())
+	})
+
+	It("should let the annotation pick an action but not escalate to removing the object", func() {
+		rule := gvkRule{Group: "apps", Version: "v1", Kind: "Deployment", action: v1alpha1.ActionLabel}
+		obj := &corev1.ConfigMap{}
+
+		action, err := objectAction(obj, rule)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(action).To(Equal(v1alpha1.ActionLabel))
+
+		obj.SetAnnotations(map[string]string{ActionAnnotation: ActionScaleToZero})
+		action, err = objectAction(obj, rule)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(action).To(Equal(v1alpha1.ActionScaleToZero))
+
+		for _, annotation := range []string{ActionDelete, ActionEvict, ActionPatch, "destroy"} {
+			obj.SetAnnotations(map[string]string{ActionAnnotation: annotation})
+			_, err = objectAction(obj, rule)
+			Expect(err).To(HaveOccurred(), annotation)
+		}
+
+		rule.action = v1alpha1.ActionDelete
+		obj.SetAnnotations(map[string]string{ActionAnnotation: ActionSuspend})
+		action, err = objectAction(obj, rule)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(action).To(Equal(v1alpha1.ActionSuspend))
+	})
+
+	It("should only take an action keeping the object once per expiry", func() {
+		expirationTime := time.Now().Add(-time.Hour)
+		obj := &corev1.ConfigMap{}
+		Expect(actioned(obj, expirationTime)).To(BeFalse())
+
+		obj.SetAnnotations(map[string]string{ActionedAtAnnotation: time.Now().UTC().Format(time.RFC3339)})
+		Expect(actioned(obj, expirationTime)).To(BeTrue())
+
+		// Renewed since, the object expires again later
+		Expect(actioned(obj, time.Now().Add(time.Hour))).To(BeFalse())
+
+		obj.SetAnnotations(map[string]string{ActionedAtAnnotation: "yesterday"})
+		Expect(actioned(obj, expirationTime)).To(BeFalse())
+	})
+
+	It("should convert the action and patch-type of the gvk-list", func() {
+		config, err := configFromConfigMap(map[string]string{
+			"check-interval": "5m",
+			"gvk-list": `- group: "apps"
+  version: "v1"
+  kind: "Deployment"
+  action: "patch"
+  patch: '[{"op":"replace","path":"/spec/paused","value":true}]'
+  patch-type: "json"
+- version: "v1"
+  kind: "Secret"`,
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(config.rules[0].action).To(Equal(v1alpha1.ActionPatch))
+		Expect(config.rules[0].patch.Type()).To(Equal(types.JSONPatchType))
+		Expect(config.rules[1].action).To(Equal(v1alpha1.ActionDelete))
+
+		_, err = configFromConfigMap(map[string]string{
+			"check-interval": "5m",
+			"gvk-list": `- version: "v1"
+  kind: "Secret"
+  action: "shred"`,
+		})
+		Expect(err).To(HaveOccurred())
+	})
+})
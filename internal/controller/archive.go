@@ -0,0 +1,58 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"errors"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"kubettlreaper/internal/archive"
+)
+
+// RestoreOptions remove the TTL and the reaper's bookkeeping from restored objects, so they
+// aren't reaped again
+var RestoreOptions = archive.RestoreOptions{
+	RemoveLabels: []string{TtlLabel, ExpiredLabel},
+	RemoveAnnotations: []string{ExpiresAtAnnotation, RenewedAtAnnotation, ActionedAtAnnotation,
+		ExpiresInAnnotation, WarnedForAnnotation},
+}
+
+// archiveObject stores the manifest of an expired object before it is deleted. The object is
+// read from the API server unless at hand, a conflict is returned when it isn't the version
+// the delete is conditional on, so it is re-evaluated
+func (r *TtlReaperReconciler) archiveObject(ctx context.Context, obj client.Object, rule gvkRule) error {
+	gvk := rule.GroupVersionKind()
+	full, isUnstructured := obj.(*unstructured.Unstructured)
+	if !isUnstructured {
+		full = &unstructured.Unstructured{}
+		full.SetGroupVersionKind(gvk)
+		if err := r.apiReader.Get(ctx, client.ObjectKeyFromObject(obj), full); err != nil {
+			return err
+		}
+	}
+	if full.GetUID() != obj.GetUID() || full.GetResourceVersion() != obj.GetResourceVersion() {
+		return apierrors.NewConflict(schema.GroupResource{Group: gvk.Group, Resource: gvk.Kind}, obj.GetName(),
+			errors.New("changed since its TTL was evaluated"))
+	}
+
+	return r.Archive.Put(ctx, archive.NewSnapshot(full))
+}
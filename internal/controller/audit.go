@@ -0,0 +1,69 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"maps"
+	"time"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"kubettlreaper/api/v1alpha1"
+	"kubettlreaper/internal/audit"
+)
+
+// audit records an action on an expired object in the audit log when one is configured,
+// failing to record it doesn't stop the action
+func (r *TtlReaperReconciler) audit(ctx context.Context, obj client.Object, rule gvkRule, action v1alpha1.ExpiryAction,
+	expirationTime time.Time, outcome string, err error) {
+	if r.AuditLog == nil {
+		return
+	}
+
+	gvk := rule.GroupVersionKind()
+	record := audit.Record{
+		Time: time.Now(),
+		Object: audit.ObjectReference{
+			APIVersion: gvk.GroupVersion().String(),
+			Kind:       gvk.Kind,
+			Namespace:  obj.GetNamespace(),
+			Name:       obj.GetName(),
+			UID:        obj.GetUID(),
+		},
+		Labels:    maps.Clone(obj.GetLabels()),
+		TTL:       obj.GetLabels()[TtlLabel],
+		ExpiresAt: expirationTime,
+		Action:    string(action),
+		Outcome:   outcome,
+	}
+	// An expires-at deadline has no anchor
+	if _, exists := obj.GetAnnotations()[ExpiresAtAnnotation]; !exists {
+		if anchor, err := getAnchorTime(obj, rule); err == nil {
+			record.AnchorTime = &anchor
+		}
+	}
+	if err != nil {
+		record.Error = err.Error()
+	}
+
+	if err := r.AuditLog.Write(record); err != nil {
+		log.FromContext(ctx).Error(err, "Failed to write audit record", "resource", obj.GetName(), "action", action)
+		auditFailedTotal.Inc()
+	}
+}
@@ -0,0 +1,120 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	sweepBackoffBase = 10 * time.Second
+	sweepBackoffMax  = 10 * time.Minute
+)
+
+// kindBackoff tracks consecutive sweep failures per GVK so a failing kind is retried
+// with exponential backoff without holding up the sweep of other kinds
+type kindBackoff struct {
+	mu       sync.Mutex
+	failures map[schema.GroupVersionKind]int
+	retryAt  map[schema.GroupVersionKind]time.Time
+}
+
+// ready reports whether the GVK is due a sweep, and if not when it is
+func (b *kindBackoff) ready(gvk schema.GroupVersionKind, now time.Time) (bool, time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	retryAt, exists := b.retryAt[gvk]
+	if !exists || !now.Before(retryAt) {
+		return true, time.Time{}
+	}
+
+	return false, retryAt
+}
+
+// failed records a failed sweep of the GVK and returns when to retry it
+func (b *kindBackoff) failed(gvk schema.GroupVersionKind, now time.Time) time.Time {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.failures == nil {
+		b.failures = map[schema.GroupVersionKind]int{}
+		b.retryAt = map[schema.GroupVersionKind]time.Time{}
+	}
+
+	b.failures[gvk]++
+	delay := sweepBackoffBase
+	for i := 1; i < b.failures[gvk] && delay < sweepBackoffMax; i++ {
+		delay *= 2
+	}
+	if delay > sweepBackoffMax {
+		delay = sweepBackoffMax
+	}
+
+	b.retryAt[gvk] = now.Add(delay)
+	return b.retryAt[gvk]
+}
+
+// succeeded resets the backoff of the GVK
+func (b *kindBackoff) succeeded(gvk schema.GroupVersionKind) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delete(b.failures, gvk)
+	delete(b.retryAt, gvk)
+}
+
+// retain forgets the backoff of GVKs no longer configured
+func (b *kindBackoff) retain(rules []gvkRule) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	configured := make(map[schema.GroupVersionKind]bool, len(rules))
+	for _, rule := range rules {
+		configured[rule.GroupVersionKind()] = true
+	}
+	for gvk := range b.retryAt {
+		if !configured[gvk] {
+			delete(b.failures, gvk)
+			delete(b.retryAt, gvk)
+		}
+	}
+}
+
+// nextRetry returns the time until the earliest retry of a backed off GVK, false if there is none
+func (b *kindBackoff) nextRetry(now time.Time) (time.Duration, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var next time.Time
+	for _, retryAt := range b.retryAt {
+		if next.IsZero() || retryAt.Before(next) {
+			next = retryAt
+		}
+	}
+	if next.IsZero() {
+		return 0, false
+	}
+	if next.Before(now) {
+		return 0, true
+	}
+
+	return next.Sub(now), true
+}
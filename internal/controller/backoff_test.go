@@ -0,0 +1,90 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+var _ = Describe("Sweep backoff", func() {
+	pods := schema.GroupVersionKind{Version: "v1", Kind: "Pod"}
+	widgets := schema.GroupVersionKind{Group: "example.com", Version: "v1", Kind: "Widget"}
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	It("should double the delay on each failure up to the max", func() {
+		b := &kindBackoff{}
+		Expect(b.failed(widgets, now)).To(Equal(now.Add(sweepBackoffBase)))
+		Expect(b.failed(widgets, now)).To(Equal(now.Add(2 * sweepBackoffBase)))
+		Expect(b.failed(widgets, now)).To(Equal(now.Add(4 * sweepBackoffBase)))
+		for i := 0; i < 20; i++ {
+			b.failed(widgets, now)
+		}
+		Expect(b.failed(widgets, now)).To(Equal(now.Add(sweepBackoffMax)))
+	})
+
+	It("should only hold back the failing GVK until its retry time", func() {
+		b := &kindBackoff{}
+		retryAt := b.failed(widgets, now)
+
+		ready, at := b.ready(widgets, now)
+		Expect(ready).To(BeFalse())
+		Expect(at).To(Equal(retryAt))
+
+		ready, _ = b.ready(pods, now)
+		Expect(ready).To(BeTrue())
+
+		ready, _ = b.ready(widgets, retryAt)
+		Expect(ready).To(BeTrue())
+	})
+
+	It("should reset on success", func() {
+		b := &kindBackoff{}
+		b.failed(widgets, now)
+		b.failed(widgets, now)
+		b.succeeded(widgets)
+
+		ready, _ := b.ready(widgets, now)
+		Expect(ready).To(BeTrue())
+		_, pending := b.nextRetry(now)
+		Expect(pending).To(BeFalse())
+		Expect(b.failed(widgets, now)).To(Equal(now.Add(sweepBackoffBase)))
+	})
+
+	It("should forget GVKs that are no longer configured", func() {
+		b := &kindBackoff{}
+		b.failed(widgets, now)
+		b.retain([]gvkRule{{Version: "v1", Kind: "Pod"}})
+
+		_, pending := b.nextRetry(now)
+		Expect(pending).To(BeFalse())
+	})
+
+	It("should report the earliest retry", func() {
+		b := &kindBackoff{}
+		b.failed(widgets, now)
+		b.failed(widgets, now)
+		b.failed(pods, now)
+
+		next, pending := b.nextRetry(now)
+		Expect(pending).To(BeTrue())
+		Expect(next).To(Equal(sweepBackoffBase))
+	})
+})
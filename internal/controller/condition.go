@@ -0,0 +1,64 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+
+	"github.com/google/cel-go/cel"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// conditionCostLimit bounds the evaluation cost of a condition, e.g. a comprehension over a huge list
+const conditionCostLimit = 1000000
+
+// conditionEnv declares the object variable conditions are evaluated against
+var conditionEnv, _ = cel.NewEnv(cel.Variable("object", cel.DynType))
+
+// compileCondition compiles a CEL condition that must evaluate to a bool, e.g.
+// object.status.phase == 'Succeeded'
+func compileCondition(expr string) (cel.Program, error) {
+	ast, issues := conditionEnv.Compile(expr)
+	if issues != nil && issues.Err() != nil {
+		return nil, fmt.Errorf("invalid condition %q: %w", expr, issues.Err())
+	}
+	if outputType := ast.OutputType(); !outputType.IsExactType(cel.BoolType) && !outputType.IsExactType(cel.DynType) {
+		return nil, fmt.Errorf("invalid condition %q: must evaluate to a bool, not %s", expr, outputType)
+	}
+
+	program, err := conditionEnv.Program(ast, cel.CostLimit(conditionCostLimit))
+	if err != nil {
+		return nil, fmt.Errorf("invalid condition %q: %w", expr, err)
+	}
+
+	return program, nil
+}
+
+// evalCondition evaluates a condition against an object, an error such as a missing field
+// means the condition doesn't hold
+func evalCondition(program cel.Program, obj *unstructured.Unstructured) (bool, error) {
+	out, _, err := program.Eval(map[string]any{"object": obj.Object})
+	if err != nil {
+		return false, err
+	}
+	holds, ok := out.Value().(bool)
+	if !ok {
+		return false, fmt.Errorf("condition evaluated to %v, not a bool", out.Value())
+	}
+
+	return holds, nil
+}
@@ -0,0 +1,96 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"kubettlreaper/api/v1alpha1"
+)
+
+var _ = Describe("Conditions", func() {
+	pod := func(phase string) *unstructured.Unstructured {
+		obj := &unstructured.Unstructured{Object: map[string]interface{}{}}
+		if phase != "" {
+			Expect(unstructured.SetNestedField(obj.Object, phase, "status", "phase")).To(Succeed())
+		}
+		return obj
+	}
+
+	It("should hold for objects in a terminal state", func() {
+		program, err := compileCondition(`object.status.phase == 'Succeeded'`)
+		Expect(err).NotTo(HaveOccurred())
+
+		holds, err := evalCondition(program, pod("Succeeded"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(holds).To(BeTrue())
+
+		holds, err = evalCondition(program, pod("Running"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(holds).To(BeFalse())
+	})
+
+	It("should not hold when a field is missing", func() {
+		program, err := compileCondition(`object.status.phase == 'Succeeded'`)
+		Expect(err).NotTo(HaveOccurred())
+
+		holds, err := evalCondition(program, pod(""))
+		Expect(err).To(HaveOccurred())
+		Expect(holds).To(BeFalse())
+	})
+
+	It("should evaluate conditions lists", func() {
+		program, err := compileCondition(
+			`!has(object.status.conditions) || !object.status.conditions.exists(c, c.type == 'Ready' && c.status == 'True')`)
+		Expect(err).NotTo(HaveOccurred())
+
+		obj := pod("Running")
+		Expect(unstructured.SetNestedSlice(obj.Object, []interface{}{
+			map[string]interface{}{"type": "Ready", "status": "True"},
+		}, "status", "conditions")).To(Succeed())
+		holds, err := evalCondition(program, obj)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(holds).To(BeFalse())
+
+		holds, err = evalCondition(program, pod("Running"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(holds).To(BeTrue())
+	})
+
+	It("should reject expressions that don't compile or aren't a bool", func() {
+		_, err := compileCondition(`object.status.phase ==`)
+		Expect(err).To(HaveOccurred())
+
+		_, err = compileCondition(`'Succeeded'`)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should keep the policy valid when a condition doesn't compile", func() {
+		config, err := newReaperConfig(v1alpha1.TtlReaperPolicySpec{
+			CheckInterval: "5m",
+			Kinds: []v1alpha1.KindRule{
+				{Version: "v1", Kind: "Pod", Condition: `object.status.phase ==`},
+				{Version: "v1", Kind: "Secret"},
+			},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(config.rules[0].conditionErr).To(HaveOccurred())
+		Expect(config.rules[1].conditionErr).NotTo(HaveOccurred())
+	})
+})
@@ -0,0 +1,537 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"cmp"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/cel-go/cel"
+	"gopkg.in/yaml.v2"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"kubettlreaper/api/v1alpha1"
+	"kubettlreaper/internal/ttl"
+)
+
+// TTL start points, i.e. what the TTL label counts down from
+const (
+	TtlStartCreation        = "creation"
+	TtlStartLabel           = "label"
+	TtlStartLastUpdate      = "last-update"
+	TtlStartConditionPrefix = "condition:"
+)
+
+// Dry run modes of the configMap
+const (
+	DryRunOff    = "false"
+	DryRunClient = "true"
+	DryRunServer = "server"
+)
+
+// configMap dry run modes and their TtlReaperPolicy equivalent
+var configMapDryRunModes = map[string]v1alpha1.DryRunMode{
+	DryRunOff:    v1alpha1.DryRunNone,
+	DryRunClient: v1alpha1.DryRunClient,
+	DryRunServer: v1alpha1.DryRunServer,
+}
+
+// gvkListEntry is an entry of the gvk-list in the configMap
+type gvkListEntry struct {
+	Group   string `yaml:"group"`
+	Version string `yaml:"version"`
+	Kind    string `yaml:"kind"`
+	// TtlStart anchors the TTL countdown, one of creation (default), label, last-update or condition:<Type>
+	TtlStart string `yaml:"ttl-start,omitempty"`
+	// MaxLifetime caps how long renewals can keep an object alive, measured from creation
+	MaxLifetime string `yaml:"max-lifetime,omitempty"`
+	// ListUnlabeled lists every object of the GVK, not only those with the TTL label
+	ListUnlabeled bool `yaml:"list-unlabeled,omitempty"`
+	// Timeout overrides the sweep-timeout for this GVK
+	Timeout string `yaml:"timeout,omitempty"`
+	// DryRun overrides the dry-run mode for this GVK, one of false, true or server
+	DryRun string `yaml:"dry-run,omitempty"`
+	// Interval overrides the check-interval for this GVK
+	Interval string `yaml:"interval,omitempty"`
+	// NamePrefixes and NameRegexes override the name-prefix for this GVK, any match is reaped
+	NamePrefixes []string `yaml:"name-prefixes,omitempty"`
+	NameRegexes  []string `yaml:"name-regexes,omitempty"`
+	// Selector is a label selector, e.g. app=ci,tier!=prod
+	Selector string `yaml:"selector,omitempty"`
+	// FieldSelector is a field selector, e.g. status.phase=Succeeded
+	FieldSelector string `yaml:"field-selector,omitempty"`
+	// Namespaces and NamespaceSelector override the namespaces and namespace-selector for this GVK
+	Namespaces        []string `yaml:"namespaces,omitempty"`
+	NamespaceSelector string   `yaml:"namespace-selector,omitempty"`
+	// Condition is a CEL expression that must hold to reap an expired object
+	Condition string `yaml:"condition,omitempty"`
+	// Action taken on expired objects, one of delete (default), scale-to-zero, suspend, patch, label or evict
+	Action string `yaml:"action,omitempty"`
+	// Patch applied by the patch action, a JSON merge patch unless PatchType is json
+	Patch     string `yaml:"patch,omitempty"`
+	PatchType string `yaml:"patch-type,omitempty"`
+	// PropagationPolicy of deletes, one of foreground, background or orphan
+	PropagationPolicy string `yaml:"propagation-policy,omitempty"`
+	// GracePeriodSeconds of deletes
+	GracePeriodSeconds *int64 `yaml:"grace-period-seconds,omitempty"`
+	// WarnBefore overrides the warn-before thresholds for this GVK
+	WarnBefore []string `yaml:"warn-before,omitempty"`
+}
+
+// configMap patch types and their TtlReaperPolicy equivalent
+var configMapPatchTypes = map[string]v1alpha1.PatchType{
+	"json":  v1alpha1.PatchJSON,
+	"merge": v1alpha1.PatchMerge,
+}
+
+// gvkRule is a validated kind of a policy with the policy defaults applied
+type gvkRule struct {
+	Group    string
+	Version  string
+	Kind     string
+	TtlStart string
+	DryRun   v1alpha1.DryRunMode
+
+	maxLifetime time.Duration
+	timeout     time.Duration
+	interval    time.Duration
+	// defaultTtl applies to objects without a TTL, only set by tenant policies
+	defaultTtl time.Duration
+	// listUnlabeled lists and watches objects without the TTL label too
+	listUnlabeled bool
+
+	// Objects the rule applies to, selector and fieldSelector are nil when unset
+	names         nameFilter
+	namespaces    namespaceFilter
+	selector      labels.Selector
+	fieldSelector fields.Selector
+
+	// condition must hold to reap an expired object, conditionErr is set instead when it
+	// doesn't compile and the rule then reaps nothing
+	condition    cel.Program
+	conditionErr error
+
+	// action taken on expired objects, patch is set for the patch action
+	action v1alpha1.ExpiryAction
+	patch  client.Patch
+
+	// Delete options, unset to use the API server defaults
+	propagationPolicy metav1.DeletionPropagation
+	gracePeriod       *int64
+
+	// warnBefore are the ExpiringSoon thresholds, longest first
+	warnBefore []time.Duration
+}
+
+// GroupVersionKind returns the GVK of the rule
+func (g gvkRule) GroupVersionKind() schema.GroupVersionKind {
+	return schema.GroupVersionKind{Group: g.Group, Version: g.Version, Kind: g.Kind}
+}
+
+// String returns the GVK of the rule as a string for logging
+func (g gvkRule) String() string {
+	return g.GroupVersionKind().String()
+}
+
+// listSelector returns the label selector to list and watch the objects of the rule with,
+// only those with the TTL label unless the rule lists unlabeled objects
+func (g gvkRule) listSelector() labels.Selector {
+	selector := g.selector
+	if selector == nil {
+		selector = labels.Everything()
+	}
+	if g.listUnlabeled {
+		return selector
+	}
+	return selector.Add(*ttlLabelRequirement)
+}
+
+// matches reports whether the rule applies to an object in a namespace, except for its field
+// selector which can only be evaluated by the API server
+func (g gvkRule) matches(obj client.Object, namespace string, nsLabels labels.Set) bool {
+	return g.names.matches(obj.GetName()) &&
+		g.namespaces.allows(namespace, nsLabels) &&
+		(g.selector == nil || g.selector.Matches(labels.Set(obj.GetLabels())))
+}
+
+// reaperConfig is a validated policy spec with defaults applied
+type reaperConfig struct {
+	checkInterval time.Duration
+	pageSize      int64
+	sweepWorkers  int
+	sweepTimeout  time.Duration
+	rules         []gvkRule
+}
+
+// rule returns the rule for a kind, false if it isn't configured
+func (c *reaperConfig) rule(gvk schema.GroupVersionKind) (gvkRule, bool) {
+	for _, rule := range c.rules {
+		if rule.GroupVersionKind() == gvk {
+			return rule, true
+		}
+	}
+	return gvkRule{}, false
+}
+
+// newReaperConfig validates a policy spec and applies the defaults
+func newReaperConfig(spec v1alpha1.TtlReaperPolicySpec) (*reaperConfig, error) {
+	checkInterval, err := ttl.ParseDuration(spec.CheckInterval)
+	if err != nil || checkInterval <= 0 {
+		return nil, fmt.Errorf("invalid check-interval %q: must be a positive duration", spec.CheckInterval)
+	}
+
+	config := &reaperConfig{
+		checkInterval: checkInterval,
+		pageSize:      defaultPageSize,
+		sweepWorkers:  defaultSweepWorkers,
+		sweepTimeout:  defaultSweepTimeout,
+	}
+
+	var maxLifetime time.Duration
+	if spec.MaxLifetime != "" {
+		if maxLifetime, err = ttl.ParseDuration(spec.MaxLifetime); err != nil {
+			return nil, fmt.Errorf("invalid max-lifetime value: %v", err)
+		}
+	}
+	names, err := newNameFilter([]string{spec.NamePrefix}, nil)
+	if err != nil {
+		return nil, err
+	}
+	namespaces, err := newNamespaceFilter(spec.Namespaces, spec.ExcludeNamespaces,
+		spec.NamespaceSelector, spec.ExcludeNamespaceSelector)
+	if err != nil {
+		return nil, err
+	}
+	warnBefore, err := parseWarnBefore(spec.WarnBefore)
+	if err != nil {
+		return nil, err
+	}
+	dryRun := v1alpha1.DryRunNone
+	if spec.DryRun != "" {
+		if err := validateDryRun(spec.DryRun); err != nil {
+			return nil, err
+		}
+		dryRun = spec.DryRun
+	}
+	if spec.PageSize < 0 {
+		return nil, fmt.Errorf("invalid page-size value %d: must be a positive integer", spec.PageSize)
+	} else if spec.PageSize > 0 {
+		config.pageSize = spec.PageSize
+	}
+	if spec.SweepWorkers < 0 {
+		return nil, fmt.Errorf("invalid sweep-workers value %d: must be a positive integer", spec.SweepWorkers)
+	} else if spec.SweepWorkers > 0 {
+		config.sweepWorkers = int(spec.SweepWorkers)
+	}
+	if spec.SweepTimeout != "" {
+		sweepTimeout, err := ttl.ParseDuration(spec.SweepTimeout)
+		if err != nil || sweepTimeout <= 0 {
+			return nil, fmt.Errorf("invalid sweep-timeout value %q: must be a positive duration", spec.SweepTimeout)
+		}
+		config.sweepTimeout = sweepTimeout
+	}
+
+	// Policy-level settings each kind starts from
+	defaults := gvkRule{
+		DryRun:      dryRun,
+		maxLifetime: maxLifetime,
+		interval:    checkInterval,
+		names:       names,
+		namespaces:  namespaces,
+		warnBefore:  warnBefore,
+	}
+
+	// Rules are looked up by GVK, a kind listed twice would be swept by one entry and reaped by the other's rule
+	listed := map[schema.GroupVersionKind]int{}
+	for i, kind := range spec.Kinds {
+		rule, err := newKindRule(i, kind, defaults, spec)
+		if err != nil {
+			return nil, err
+		}
+		if first, exists := listed[rule.GroupVersionKind()]; exists {
+			return nil, fmt.Errorf("kind %d (%s): already listed as kind %d", i, rule, first)
+		}
+		listed[rule.GroupVersionKind()] = i
+		config.rules = append(config.rules, rule)
+	}
+
+	return config, nil
+}
+
+// newKindRule validates the i-th kind of a policy spec and applies the policy defaults to it
+func newKindRule(i int, kind v1alpha1.KindRule, defaults gvkRule, spec v1alpha1.TtlReaperPolicySpec) (gvkRule, error) {
+	rule := defaults
+	rule.Group = kind.Group
+	rule.Version = kind.Version
+	rule.Kind = kind.Kind
+	rule.TtlStart = kind.TtlStart
+	rule.listUnlabeled = kind.ListUnlabeled
+	rule.action = cmp.Or(kind.Action, v1alpha1.ActionDelete)
+	rule.propagationPolicy = kind.PropagationPolicy
+	rule.gracePeriod = kind.GracePeriodSeconds
+
+	if rule.Version == "" || rule.Kind == "" {
+		return rule, fmt.Errorf("kind %d (%s): version and kind are required", i, rule)
+	}
+	if err := validateTtlStart(kind.TtlStart); err != nil {
+		return rule, fmt.Errorf("kind %d (%s): %w", i, rule, err)
+	}
+	var err error
+	if kind.MaxLifetime != "" {
+		if rule.maxLifetime, err = ttl.ParseDuration(kind.MaxLifetime); err != nil {
+			return rule, fmt.Errorf("kind %d (%s): invalid max-lifetime: %w", i, rule, err)
+		}
+	}
+	if kind.DryRun != "" {
+		if err := validateDryRun(kind.DryRun); err != nil {
+			return rule, fmt.Errorf("kind %d (%s): %w", i, rule, err)
+		}
+		rule.DryRun = kind.DryRun
+	}
+	if kind.Timeout != "" {
+		timeout, err := ttl.ParseDuration(kind.Timeout)
+		if err != nil || timeout <= 0 {
+			return rule, fmt.Errorf("kind %d (%s): invalid timeout %q", i, rule, kind.Timeout)
+		}
+		rule.timeout = timeout
+	}
+	if kind.Interval != "" {
+		interval, err := ttl.ParseDuration(kind.Interval)
+		if err != nil || interval <= 0 {
+			return rule, fmt.Errorf("kind %d (%s): invalid interval %q: must be a positive duration", i, rule, kind.Interval)
+		}
+		rule.interval = interval
+	}
+	if err := rule.selectObjects(kind, spec); err != nil {
+		return rule, fmt.Errorf("kind %d (%s): %w", i, rule, err)
+	}
+	if len(kind.WarnBefore) > 0 {
+		if rule.warnBefore, err = parseWarnBefore(kind.WarnBefore); err != nil {
+			return rule, fmt.Errorf("kind %d (%s): %w", i, rule, err)
+		}
+	}
+	if err := validateDeletion(kind.PropagationPolicy, kind.GracePeriodSeconds); err != nil {
+		return rule, fmt.Errorf("kind %d (%s): %w", i, rule, err)
+	}
+	if rule.patch, err = newActionPatch(rule.GroupVersionKind(), rule.action, kind.Patch, kind.PatchType); err != nil {
+		return rule, fmt.Errorf("kind %d (%s): %w", i, rule, err)
+	}
+	if kind.Condition != "" {
+		// Not fatal to the policy, the other kinds keep being reaped
+		if rule.condition, err = compileCondition(kind.Condition); err != nil {
+			rule.conditionErr = fmt.Errorf("kind %d (%s): %w", i, rule, err)
+		}
+	}
+	return rule, nil
+}
+
+// selectObjects sets the name, namespace, label and field filters of a kind that override
+// or narrow the policy's
+func (g *gvkRule) selectObjects(kind v1alpha1.KindRule, spec v1alpha1.TtlReaperPolicySpec) error {
+	var err error
+	if len(kind.NamePrefixes) > 0 || len(kind.NameRegexes) > 0 {
+		if g.names, err = newNameFilter(kind.NamePrefixes, kind.NameRegexes); err != nil {
+			return err
+		}
+	}
+	if len(kind.Namespaces) > 0 || kind.NamespaceSelector != nil {
+		// Only the namespaces reaped in are overridden, exclusions always apply
+		if g.namespaces, err = newNamespaceFilter(kind.Namespaces, spec.ExcludeNamespaces,
+			kind.NamespaceSelector, spec.ExcludeNamespaceSelector); err != nil {
+			return err
+		}
+	}
+	if kind.Selector != nil {
+		if g.selector, err = metav1.LabelSelectorAsSelector(kind.Selector); err != nil {
+			return fmt.Errorf("invalid selector: %w", err)
+		}
+	}
+	if kind.FieldSelector != "" {
+		if g.fieldSelector, err = fields.ParseSelector(kind.FieldSelector); err != nil {
+			return fmt.Errorf("invalid field selector: %w", err)
+		}
+	}
+	return nil
+}
+
+// policySpecFromConfigMap converts the configMap format to a policy spec, values
+// are only checked for type here and validated by newReaperConfig
+func policySpecFromConfigMap(configMap *corev1.ConfigMap) (v1alpha1.TtlReaperPolicySpec, error) {
+	spec := v1alpha1.TtlReaperPolicySpec{
+		CheckInterval:     configMap.Data["check-interval"],
+		NamePrefix:        configMap.Data["name-prefix"],
+		Namespaces:        splitList(configMap.Data["namespaces"]),
+		ExcludeNamespaces: splitList(configMap.Data["exclude-namespaces"]),
+		MaxLifetime:       configMap.Data["max-lifetime"],
+		SweepTimeout:      configMap.Data["sweep-timeout"],
+		WarnBefore:        splitList(configMap.Data["warn-before"]),
+	}
+	if spec.CheckInterval == "" {
+		return spec, fmt.Errorf("check-interval not found in ConfigMap")
+	}
+
+	var err error
+	if selector, exists := configMap.Data["namespace-selector"]; exists {
+		if spec.NamespaceSelector, err = metav1.ParseToLabelSelector(selector); err != nil {
+			return spec, fmt.Errorf("invalid namespace-selector %q: %w", selector, err)
+		}
+	}
+	if selector, exists := configMap.Data["exclude-namespace-selector"]; exists {
+		if spec.ExcludeNamespaceSelector, err = metav1.ParseToLabelSelector(selector); err != nil {
+			return spec, fmt.Errorf("invalid exclude-namespace-selector %q: %w", selector, err)
+		}
+	}
+
+	if dryRun, exists := configMap.Data["dry-run"]; exists {
+		mode, err := convertDryRun(dryRun)
+		if err != nil {
+			return spec, err
+		}
+		spec.DryRun = mode
+	}
+	if pageSizeStr, exists := configMap.Data["page-size"]; exists {
+		pageSize, err := strconv.ParseInt(pageSizeStr, 10, 64)
+		if err != nil || pageSize <= 0 {
+			return spec, fmt.Errorf("invalid page-size value %q: must be a positive integer", pageSizeStr)
+		}
+		spec.PageSize = pageSize
+	}
+	if workersStr, exists := configMap.Data["sweep-workers"]; exists {
+		workers, err := strconv.ParseInt(workersStr, 10, 32)
+		if err != nil || workers <= 0 {
+			return spec, fmt.Errorf("invalid sweep-workers value %q: must be a positive integer", workersStr)
+		}
+		spec.SweepWorkers = int32(workers)
+	}
+
+	entries, err := parseGvkList(configMap.Data["gvk-list"])
+	if err != nil {
+		return spec, err
+	}
+	for i, entry := range entries {
+		kind := v1alpha1.KindRule{
+			Group:         entry.Group,
+			Version:       entry.Version,
+			Kind:          entry.Kind,
+			TtlStart:      entry.TtlStart,
+			MaxLifetime:   entry.MaxLifetime,
+			ListUnlabeled: entry.ListUnlabeled,
+			Timeout:       entry.Timeout,
+			Interval:      entry.Interval,
+			NamePrefixes:  entry.NamePrefixes,
+			NameRegexes:   entry.NameRegexes,
+			FieldSelector: entry.FieldSelector,
+			Namespaces:    entry.Namespaces,
+			Condition:     entry.Condition,
+			Patch:         entry.Patch,
+
+			GracePeriodSeconds: entry.GracePeriodSeconds,
+			WarnBefore:         entry.WarnBefore,
+		}
+		if entry.DryRun != "" {
+			if kind.DryRun, err = convertDryRun(entry.DryRun); err != nil {
+				return spec, fmt.Errorf("gvk-list entry %d: %w", i, err)
+			}
+		}
+		if entry.Action != "" {
+			if kind.Action, err = convertAction(entry.Action); err != nil {
+				return spec, fmt.Errorf("gvk-list entry %d: %w", i, err)
+			}
+		}
+		if entry.PatchType != "" {
+			patchType, ok := configMapPatchTypes[entry.PatchType]
+			if !ok {
+				return spec, fmt.Errorf("gvk-list entry %d: invalid patch-type %q, expected json or merge", i, entry.PatchType)
+			}
+			kind.PatchType = patchType
+		}
+		if entry.PropagationPolicy != "" {
+			if kind.PropagationPolicy, err = convertPropagationPolicy(entry.PropagationPolicy); err != nil {
+				return spec, fmt.Errorf("gvk-list entry %d: %w", i, err)
+			}
+		}
+		if entry.Selector != "" {
+			if kind.Selector, err = metav1.ParseToLabelSelector(entry.Selector); err != nil {
+				return spec, fmt.Errorf("gvk-list entry %d: invalid selector %q: %w", i, entry.Selector, err)
+			}
+		}
+		if entry.NamespaceSelector != "" {
+			if kind.NamespaceSelector, err = metav1.ParseToLabelSelector(entry.NamespaceSelector); err != nil {
+				return spec, fmt.Errorf("gvk-list entry %d: invalid namespace-selector %q: %w", i, entry.NamespaceSelector, err)
+			}
+		}
+		spec.Kinds = append(spec.Kinds, kind)
+	}
+
+	return spec, nil
+}
+
+// parseGvkList parses the gvk-list from the configMap
+func parseGvkList(data string) ([]gvkListEntry, error) {
+	var entries []gvkListEntry
+	if err := yaml.Unmarshal([]byte(data), &entries); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// convertDryRun converts a configMap dry-run setting to a policy dry run mode
+func convertDryRun(dryRun string) (v1alpha1.DryRunMode, error) {
+	mode, ok := configMapDryRunModes[dryRun]
+	if !ok {
+		return "", fmt.Errorf("invalid dry-run %q, expected one of %s, %s or %s", dryRun, DryRunOff, DryRunClient, DryRunServer)
+	}
+
+	return mode, nil
+}
+
+// validateTtlStart checks a ttl-start setting, empty means creation
+func validateTtlStart(start string) error {
+	switch start {
+	case "", TtlStartCreation, TtlStartLabel, TtlStartLastUpdate:
+		return nil
+	}
+	if conditionType, ok := strings.CutPrefix(start, TtlStartConditionPrefix); ok {
+		if conditionType == "" {
+			return fmt.Errorf("ttl-start %q is missing a condition type", start)
+		}
+		return nil
+	}
+
+	return fmt.Errorf("invalid ttl-start %q, expected one of %s, %s, %s or %s<Type>",
+		start, TtlStartCreation, TtlStartLabel, TtlStartLastUpdate, TtlStartConditionPrefix)
+}
+
+// validateDryRun checks a policy dry run mode
+func validateDryRun(dryRun v1alpha1.DryRunMode) error {
+	switch dryRun {
+	case v1alpha1.DryRunNone, v1alpha1.DryRunClient, v1alpha1.DryRunServer:
+		return nil
+	}
+
+	return fmt.Errorf("invalid dry run mode %q, expected one of %s, %s or %s",
+		dryRun, v1alpha1.DryRunNone, v1alpha1.DryRunClient, v1alpha1.DryRunServer)
+}
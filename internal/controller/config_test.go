@@ -0,0 +1,299 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+
+	"kubettlreaper/api/v1alpha1"
+)
+
+// configFromConfigMap converts and validates configMap data like the reconciler does
+func configFromConfigMap(data map[string]string) (*reaperConfig, error) {
+	spec, err := policySpecFromConfigMap(&corev1.ConfigMap{Data: data})
+	if err != nil {
+		return nil, err
+	}
+	return newReaperConfig(spec)
+}
+
+var _ = Describe("ConfigMap conversion", func() {
+	It("should parse a plain GVK list", func() {
+		config, err := configFromConfigMap(map[string]string{
+			"check-interval": "5m",
+			"gvk-list": `- group: ""
+  version: "v1"
+  kind: "Secret"
+- group: "rbac.authorization.k8s.io"
+  version: "v1"
+  kind: "RoleBinding"`,
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(config.checkInterval).To(Equal(5 * time.Minute))
+		Expect(config.rules).To(HaveLen(2))
+		Expect(config.rules[1].String()).To(Equal("rbac.authorization.k8s.io/v1, Kind=RoleBinding"))
+	})
+
+	It("should only list objects with the TTL label unless the kind lists unlabeled ones", func() {
+		config, err := configFromConfigMap(map[string]string{
+			"check-interval": "5m",
+			"gvk-list": `- version: "v1"
+  kind: "Secret"
+  selector: app=ci
+- version: "v1"
+  kind: "ConfigMap"
+  list-unlabeled: true`,
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(config.rules[0].listSelector().String()).To(Equal("app=ci," + TtlLabel))
+		Expect(config.rules[1].listUnlabeled).To(BeTrue())
+		Expect(config.rules[1].listSelector().Empty()).To(BeTrue())
+	})
+
+	It("should apply the defaults", func() {
+		config, err := configFromConfigMap(map[string]string{"check-interval": "1h"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(config.pageSize).To(Equal(int64(defaultPageSize)))
+		Expect(config.sweepWorkers).To(Equal(defaultSweepWorkers))
+		Expect(config.sweepTimeout).To(Equal(defaultSweepTimeout))
+		Expect(config.rules).To(BeEmpty())
+	})
+
+	It("should parse the namespace lists and selectors", func() {
+		config, err := configFromConfigMap(map[string]string{
+			"check-interval":             "5m",
+			"namespaces":                 "ci, preview-*",
+			"exclude-namespaces":         "preview-keep",
+			"namespace-selector":         "env=preview",
+			"exclude-namespace-selector": "kubettlreaper.samir.io/protected",
+			"gvk-list": `- version: "v1"
+  kind: "Pod"`,
+		})
+		Expect(err).NotTo(HaveOccurred())
+		namespaces := config.rules[0].namespaces
+		Expect(namespaces.include).To(Equal([]string{"ci", "preview-*"}))
+		Expect(namespaces.exclude).To(Equal([]string{"preview-keep"}))
+		Expect(namespaces.includeSelector.String()).To(Equal("env=preview"))
+		Expect(namespaces.excludeSelector.String()).To(Equal("kubettlreaper.samir.io/protected"))
+
+		_, err = configFromConfigMap(map[string]string{"check-interval": "5m", "namespace-selector": "env in"})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should parse rich gvk-list entries", func() {
+		config, err := configFromConfigMap(map[string]string{
+			"check-interval":     "5m",
+			"name-prefix":        "tmp-",
+			"exclude-namespaces": "ci-keep",
+			"gvk-list": `- version: "v1"
+  kind: "Pod"
+  namespaces: ["ci-*"]
+  selector: "app=runner"
+  field-selector: "status.phase=Succeeded"
+  interval: "1m"
+- group: "rbac.authorization.k8s.io"
+  version: "v1"
+  kind: "RoleBinding"
+  name-prefixes: ["jit-", "break-glass-"]
+  name-regexes: ["^pr-[0-9]+-"]`,
+		})
+		Expect(err).NotTo(HaveOccurred())
+		pods, roleBindings := config.rules[0], config.rules[1]
+
+		Expect(pods.interval).To(Equal(time.Minute))
+		Expect(pods.selector.String()).To(Equal("app=runner"))
+		Expect(pods.fieldSelector.String()).To(Equal("status.phase=Succeeded"))
+		Expect(pods.names.matches("tmp-runner")).To(BeTrue())
+		Expect(pods.namespaces.allows("ci-42", nil)).To(BeTrue())
+		Expect(pods.namespaces.allows("ci-keep", nil)).To(BeFalse())
+		Expect(pods.namespaces.allows("default", nil)).To(BeFalse())
+
+		Expect(roleBindings.interval).To(Equal(5 * time.Minute))
+		Expect(roleBindings.names.matches("jit-alice")).To(BeTrue())
+		Expect(roleBindings.names.matches("break-glass-bob")).To(BeTrue())
+		Expect(roleBindings.names.matches("pr-1234-reader")).To(BeTrue())
+		Expect(roleBindings.names.matches("tmp-reader")).To(BeFalse())
+		Expect(roleBindings.namespaces.allows("default", nil)).To(BeTrue())
+	})
+
+	It("should reject invalid selectors, regexes and intervals in the gvk-list", func() {
+		for _, entry := range []string{
+			`selector: "app in"`,
+			`field-selector: "status.phase"`,
+			`name-regexes: ["pr-("]`,
+			`interval: "0s"`,
+		} {
+			_, err := configFromConfigMap(map[string]string{
+				"check-interval": "5m",
+				"gvk-list": `- version: "v1"
+  kind: "Pod"
+  ` + entry,
+			})
+			Expect(err).To(HaveOccurred(), entry)
+		}
+	})
+
+	It("should require a valid check-interval", func() {
+		_, err := configFromConfigMap(map[string]string{})
+		Expect(err).To(HaveOccurred())
+
+		_, err = configFromConfigMap(map[string]string{"check-interval": "soon"})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should reject an invalid ttl-start in the gvk-list", func() {
+		_, err := configFromConfigMap(map[string]string{
+			"check-interval": "5m",
+			"gvk-list": `- version: "v1"
+  kind: "Pod"
+  ttl-start: "whenever"`,
+		})
+		Expect(err).To(HaveOccurred())
+
+		config, err := configFromConfigMap(map[string]string{
+			"check-interval": "5m",
+			"gvk-list": `- group: "batch"
+  version: "v1"
+  kind: "Job"
+  ttl-start: "condition:Complete"`,
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(config.rules).To(HaveLen(1))
+		Expect(config.rules[0].TtlStart).To(Equal("condition:Complete"))
+	})
+
+	It("should parse max-lifetime in the gvk-list and fall back to the global one", func() {
+		config, err := configFromConfigMap(map[string]string{
+			"check-interval": "5m",
+			"max-lifetime":   "30d",
+			"gvk-list": `- version: "v1"
+  kind: "Namespace"
+  max-lifetime: "2w"
+- version: "v1"
+  kind: "Secret"`,
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(config.rules[0].maxLifetime).To(Equal(14 * 24 * time.Hour))
+		Expect(config.rules[1].maxLifetime).To(Equal(30 * 24 * time.Hour))
+
+		_, err = configFromConfigMap(map[string]string{
+			"check-interval": "5m",
+			"gvk-list": `- version: "v1"
+  kind: "Namespace"
+  max-lifetime: "forever"`,
+		})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should convert dry-run in the gvk-list to policy dry run modes", func() {
+		config, err := configFromConfigMap(map[string]string{
+			"check-interval": "5m",
+			"dry-run":        "true",
+			"gvk-list": `- version: "v1"
+  kind: "Pod"
+  dry-run: "server"
+- version: "v1"
+  kind: "Secret"`,
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(config.rules[0].DryRun).To(Equal(v1alpha1.DryRunServer))
+		Expect(config.rules[1].DryRun).To(Equal(v1alpha1.DryRunClient))
+
+		_, err = configFromConfigMap(map[string]string{
+			"check-interval": "5m",
+			"gvk-list": `- version: "v1"
+  kind: "Pod"
+  dry-run: "maybe"`,
+		})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should reject invalid sweep settings", func() {
+		for key, value := range map[string]string{
+			"page-size":     "0",
+			"sweep-workers": "many",
+			"sweep-timeout": "-1m",
+		} {
+			_, err := configFromConfigMap(map[string]string{"check-interval": "5m", key: value})
+			Expect(err).To(HaveOccurred(), key)
+		}
+	})
+})
+
+var _ = Describe("TtlReaperPolicy spec validation", func() {
+	It("should apply the policy defaults to kinds", func() {
+		config, err := newReaperConfig(v1alpha1.TtlReaperPolicySpec{
+			CheckInterval: "10m",
+			DryRun:        v1alpha1.DryRunClient,
+			SweepWorkers:  2,
+			Kinds: []v1alpha1.KindRule{
+				{Version: "v1", Kind: "ConfigMap"},
+				{Group: "apps", Version: "v1", Kind: "Deployment", DryRun: v1alpha1.DryRunNone, Timeout: "30s"},
+			},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(config.sweepWorkers).To(Equal(2))
+		Expect(config.rules[0].DryRun).To(Equal(v1alpha1.DryRunClient))
+		Expect(config.rules[1].DryRun).To(Equal(v1alpha1.DryRunNone))
+		Expect(config.rules[1].timeout).To(Equal(30 * time.Second))
+	})
+
+	It("should reject a kind without a version", func() {
+		_, err := newReaperConfig(v1alpha1.TtlReaperPolicySpec{
+			CheckInterval: "10m",
+			Kinds:         []v1alpha1.KindRule{{Kind: "ConfigMap"}},
+		})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should reject a kind listed twice", func() {
+		_, err := newReaperConfig(v1alpha1.TtlReaperPolicySpec{
+			CheckInterval: "10m",
+			Kinds: []v1alpha1.KindRule{
+				{Version: "v1", Kind: "ConfigMap", TtlStart: TtlStartLabel},
+				{Version: "v1", Kind: "Secret"},
+				{Version: "v1", Kind: "ConfigMap", Action: v1alpha1.ActionLabel},
+			},
+		})
+		Expect(err).To(MatchError(ContainSubstring("Kind=ConfigMap): already listed as kind 0")))
+	})
+
+	It("should parse warn-before and override it in the gvk-list", func() {
+		config, err := configFromConfigMap(map[string]string{
+			"check-interval": "5m",
+			"warn-before":    "1h, 24h",
+			"gvk-list": `- version: "v1"
+  kind: "Namespace"
+  warn-before: ["7d"]
+- version: "v1"
+  kind: "Secret"`,
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(config.rules[0].warnBefore).To(Equal([]time.Duration{7 * 24 * time.Hour}))
+		Expect(config.rules[1].warnBefore).To(Equal([]time.Duration{24 * time.Hour, time.Hour}))
+
+		_, err = configFromConfigMap(map[string]string{
+			"check-interval": "5m",
+			"warn-before":    "later",
+		})
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,188 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"slices"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+// ConfigurationLabel marks a ConfigMap in the operator namespace or a TtlReaperPolicy
+// as a configuration to run, in addition to those named by --configuration-name
+const ConfigurationLabel = "kubettlreaper.samir.io/configuration"
+
+// configState is a loaded configuration, shared with the expiry controller
+type configState struct {
+	name     string
+	config   *reaperConfig
+	rules    map[schema.GroupVersionKind]gvkRule
+	backoff  kindBackoff
+	schedule kindSchedule
+}
+
+// isConfiguration reports whether a ConfigMap or TtlReaperPolicy is a configuration to run
+func (r *TtlReaperReconciler) isConfiguration(obj client.Object) bool {
+	if obj.GetNamespace() != "" && obj.GetNamespace() != OperatorNamespace {
+		return false
+	}
+	return slices.Contains(r.ConfigurationNames, obj.GetName()) || obj.GetLabels()[ConfigurationLabel] == "true"
+}
+
+// configurationPredicate passes configurations, updates pass when either side is a
+// configuration so removing the marker label unloads it
+func (r *TtlReaperReconciler) configurationPredicate() predicate.Predicate {
+	return predicate.Funcs{
+		CreateFunc: func(e event.CreateEvent) bool {
+			return r.isConfiguration(e.Object)
+		},
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			return r.isConfiguration(e.ObjectOld) || r.isConfiguration(e.ObjectNew)
+		},
+		DeleteFunc: func(e event.DeleteEvent) bool {
+			return r.isConfiguration(e.Object)
+		},
+		GenericFunc: func(e event.GenericEvent) bool {
+			return r.isConfiguration(e.Object)
+		},
+	}
+}
+
+// setConfiguration stores a validated configuration, keeping the backoff and schedule of a
+// configuration already loaded
+func (r *TtlReaperReconciler) setConfiguration(name string, config *reaperConfig) *configState {
+	rules := make(map[schema.GroupVersionKind]gvkRule, len(config.rules))
+	for _, rule := range config.rules {
+		rules[rule.GroupVersionKind()] = rule
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.configs == nil {
+		r.configs = map[string]*configState{}
+	}
+	state, exists := r.configs[name]
+	if !exists {
+		state = &configState{name: name}
+		r.configs[name] = state
+		r.configOrder = append(r.configOrder, name)
+		slices.Sort(r.configOrder)
+	}
+	state.config = config
+	state.rules = rules
+
+	return state
+}
+
+// forgetConfiguration unloads a configuration that was deleted or is no longer marked
+func (r *TtlReaperReconciler) forgetConfiguration(name string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exists := r.configs[name]; !exists {
+		return false
+	}
+	delete(r.configs, name)
+	r.configOrder = slices.DeleteFunc(r.configOrder, func(n string) bool { return n == name })
+
+	metricLabels := prometheus.Labels{"configuration": name}
+	sweepDuration.DeletePartialMatch(metricLabels)
+	pendingExpiry.DeletePartialMatch(metricLabels)
+	pendingDeletion.DeletePartialMatch(metricLabels)
+	lastSuccessfulSweep.DeletePartialMatch(metricLabels)
+
+	return true
+}
+
+// namedConfig is a loaded configuration and its name
+type namedConfig struct {
+	name   string
+	config *reaperConfig
+}
+
+// configurations returns the loaded configurations in name order
+func (r *TtlReaperReconciler) configurations() []namedConfig {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	configs := make([]namedConfig, 0, len(r.configOrder))
+	for _, name := range r.configOrder {
+		configs = append(configs, namedConfig{name: name, config: r.configs[name].config})
+	}
+	return configs
+}
+
+// setTenants stores the tenant rules merged with the loaded configurations
+func (r *TtlReaperReconciler) setTenants(tenants tenantRules) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.tenants = tenants
+}
+
+// getRule returns the rule for an object of a kind and the configuration that claims it,
+// the first configuration by name configuring the kind and matching the object's name,
+// namespace and labels wins when they overlap, field selectors aren't considered.
+// A tenant rule of the object's namespace that selects it takes precedence over the
+// configuration's rule. False if no configuration claims the object
+func (r *TtlReaperReconciler) getRule(ctx context.Context, gvk schema.GroupVersionKind, obj client.Object) (gvkRule, string, bool) {
+	// Namespace labels are read from the cache, only when a configuration selects by them
+	namespace := objectNamespace(gvk, obj)
+	var nsLabels labels.Set
+	if namespace != "" && r.namespaceLabelsNeeded() {
+		nsLabels = r.namespaceLabels(ctx, namespace)
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, name := range r.configOrder {
+		state := r.configs[name]
+		rule, ok := state.rules[gvk]
+		if !ok || !rule.matches(obj, namespace, nsLabels) {
+			continue
+		}
+		if tenantRule, ok := r.tenants.match(gvk, obj, name); ok {
+			rule = tenantRule
+		}
+		return rule, name, true
+	}
+	return gvkRule{}, "", false
+}
+
+// namespaceLabelsNeeded reports whether any configuration selects namespaces by label
+func (r *TtlReaperReconciler) namespaceLabelsNeeded() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, state := range r.configs {
+		for _, rule := range state.rules {
+			if rule.namespaces.needsLabels() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// configurationRequest is the reconcile request of a configuration, ConfigMap and
+// TtlReaperPolicy events of the same name share it
+func configurationRequest(name string) ctrl.Request {
+	return ctrl.Request{NamespacedName: client.ObjectKey{Namespace: OperatorNamespace, Name: name}}
+}
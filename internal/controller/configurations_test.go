@@ -0,0 +1,116 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"kubettlreaper/api/v1alpha1"
+)
+
+var _ = Describe("Configurations", func() {
+	secretGVK := schema.GroupVersionKind{Version: "v1", Kind: "Secret"}
+
+	newConfig := func(namePrefix string) *reaperConfig {
+		config, err := newReaperConfig(v1alpha1.TtlReaperPolicySpec{
+			CheckInterval: "5m",
+			NamePrefix:    namePrefix,
+			Kinds:         []v1alpha1.KindRule{{Version: "v1", Kind: "Secret"}},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		return config
+	}
+
+	secret := func(name string) *metav1.PartialObjectMetadata {
+		return &metav1.PartialObjectMetadata{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"}}
+	}
+
+	It("should run named and marked configurations in the operator namespace", func() {
+		r := &TtlReaperReconciler{ConfigurationNames: []string{"kube-ttl-reaper"}}
+		configMap := func(name, namespace string, labels map[string]string) *corev1.ConfigMap {
+			return &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, Labels: labels}}
+		}
+		marker := map[string]string{ConfigurationLabel: "true"}
+
+		Expect(r.isConfiguration(configMap("kube-ttl-reaper", OperatorNamespace, nil))).To(BeTrue())
+		Expect(r.isConfiguration(configMap("ci", OperatorNamespace, marker))).To(BeTrue())
+		Expect(r.isConfiguration(configMap("ci", OperatorNamespace, nil))).To(BeFalse())
+		Expect(r.isConfiguration(configMap("ci", "elsewhere", marker))).To(BeFalse())
+		Expect(r.isConfiguration(&v1alpha1.TtlReaperPolicy{ObjectMeta: metav1.ObjectMeta{Name: "ci", Labels: marker}})).
+			To(BeTrue())
+	})
+
+	It("should let the first configuration by name claim overlapping objects", func() {
+		r := &TtlReaperReconciler{}
+		r.setConfiguration("tmp", newConfig("tmp-"))
+		r.setConfiguration("all", newConfig(""))
+		r.setConfiguration("jit", newConfig("jit-"))
+
+		_, owner, ok := r.getRule(ctx, secretGVK, secret("tmp-token"))
+		Expect(ok).To(BeTrue())
+		Expect(owner).To(Equal("all"))
+
+		By("forgetting a configuration")
+		Expect(r.forgetConfiguration("all")).To(BeTrue())
+		_, owner, _ = r.getRule(ctx, secretGVK, secret("tmp-token"))
+		Expect(owner).To(Equal("tmp"))
+		_, owner, _ = r.getRule(ctx, secretGVK, secret("jit-token"))
+		Expect(owner).To(Equal("jit"))
+		_, _, ok = r.getRule(ctx, secretGVK, secret("token"))
+		Expect(ok).To(BeFalse())
+	})
+
+	It("should let a configuration whose rule doesn't select an object leave it to the next", func() {
+		ci, err := newReaperConfig(v1alpha1.TtlReaperPolicySpec{
+			CheckInterval: "5m",
+			Kinds: []v1alpha1.KindRule{{
+				Version:  "v1",
+				Kind:     "Secret",
+				Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "ci"}},
+			}},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		r := &TtlReaperReconciler{}
+		r.setConfiguration("ci", ci)
+		r.setConfiguration("tmp", newConfig("tmp-"))
+
+		token := secret("tmp-token")
+		_, owner, _ := r.getRule(ctx, secretGVK, token)
+		Expect(owner).To(Equal("tmp"))
+
+		token.SetLabels(map[string]string{"app": "ci"})
+		_, owner, _ = r.getRule(ctx, secretGVK, token)
+		Expect(owner).To(Equal("ci"))
+	})
+
+	It("should keep the backoff of a configuration when it is updated", func() {
+		r := &TtlReaperReconciler{}
+		state := r.setConfiguration("tmp", newConfig("tmp-"))
+		state.backoff.failed(secretGVK, time.Now())
+
+		updated := r.setConfiguration("tmp", newConfig("temp-"))
+		Expect(updated).To(BeIdenticalTo(state))
+		_, pending := updated.backoff.nextRetry(time.Now())
+		Expect(pending).To(BeTrue())
+	})
+})
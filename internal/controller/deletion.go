@@ -0,0 +1,107 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	// PropagationPolicyAnnotation overrides the propagation policy of the kind for an object, e.g. foreground
+	PropagationPolicyAnnotation = "kubettlreaper.samir.io/propagation-policy"
+	// GracePeriodAnnotation overrides the grace period of the kind for an object, in seconds
+	GracePeriodAnnotation = "kubettlreaper.samir.io/grace-period-seconds"
+)
+
+// configMap and annotation propagation policies and their TtlReaperPolicy equivalent
+var configMapPropagationPolicies = map[string]metav1.DeletionPropagation{
+	"foreground": metav1.DeletePropagationForeground,
+	"background": metav1.DeletePropagationBackground,
+	"orphan":     metav1.DeletePropagationOrphan,
+}
+
+// convertPropagationPolicy converts a configMap or annotation propagation policy, in
+// either case, to a policy one
+func convertPropagationPolicy(policy string) (metav1.DeletionPropagation, error) {
+	converted, ok := configMapPropagationPolicies[strings.ToLower(policy)]
+	if !ok {
+		return "", fmt.Errorf("invalid propagation policy %q, expected one of foreground, background or orphan", policy)
+	}
+
+	return converted, nil
+}
+
+// validateDeletion checks the propagation policy and grace period of a kind
+func validateDeletion(policy metav1.DeletionPropagation, gracePeriod *int64) error {
+	switch policy {
+	case "", metav1.DeletePropagationForeground, metav1.DeletePropagationBackground, metav1.DeletePropagationOrphan:
+	default:
+		return fmt.Errorf("invalid propagation policy %q, expected one of %s, %s or %s", policy,
+			metav1.DeletePropagationForeground, metav1.DeletePropagationBackground, metav1.DeletePropagationOrphan)
+	}
+	if gracePeriod != nil && *gracePeriod < 0 {
+		return fmt.Errorf("invalid grace period %d: must not be negative", *gracePeriod)
+	}
+
+	return nil
+}
+
+// deleteOptions returns the options to delete an expired object with, the annotations
+// override the propagation policy and grace period of the kind
+func deleteOptions(obj client.Object, rule gvkRule) ([]client.DeleteOption, error) {
+	policy, gracePeriod := rule.propagationPolicy, rule.gracePeriod
+	if annotation, exists := obj.GetAnnotations()[PropagationPolicyAnnotation]; exists {
+		var err error
+		if policy, err = convertPropagationPolicy(annotation); err != nil {
+			return nil, err
+		}
+	}
+	if annotation, exists := obj.GetAnnotations()[GracePeriodAnnotation]; exists {
+		seconds, err := strconv.ParseInt(annotation, 10, 64)
+		if err != nil || seconds < 0 {
+			return nil, fmt.Errorf("invalid grace period %q: must be a non-negative number of seconds", annotation)
+		}
+		gracePeriod = &seconds
+	}
+
+	var opts []client.DeleteOption
+	if policy != "" {
+		opts = append(opts, client.PropagationPolicy(policy))
+	}
+	if gracePeriod != nil {
+		opts = append(opts, client.GracePeriodSeconds(*gracePeriod))
+	}
+	return opts, nil
+}
+
+// reportPendingDeletion reports an expired object still being deleted at a sweep, e.g. a
+// foreground deletion waiting on its dependents or a finalizer that isn't removed
+func (r *TtlReaperReconciler) reportPendingDeletion(ctx context.Context, obj client.Object) {
+	pendingFor := time.Since(obj.GetDeletionTimestamp().Time).Round(time.Second)
+	log.FromContext(ctx).Info("Deletion of expired resource not completed", "resource", obj.GetName(),
+		"namespace", obj.GetNamespace(), "pendingFor", pendingFor, "finalizers", obj.GetFinalizers())
+	r.raiseEvent(obj, "Warning", "DeletionPending", eventActionReap,
+		fmt.Sprintf("Deletion pending for %s, waiting on finalizers %v", pendingFor, obj.GetFinalizers()))
+}
@@ -0,0 +1,103 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var _ = Describe("Delete options", func() {
+	// applied returns the delete options an object is deleted with
+	applied := func(obj client.Object, rule gvkRule) (*client.DeleteOptions, error) {
+		opts, err := deleteOptions(obj, rule)
+		if err != nil {
+			return nil, err
+		}
+		return (&client.DeleteOptions{}).ApplyOptions(opts), nil
+	}
+
+	It("should leave the API server defaults when unset", func() {
+		opts, err := applied(&corev1.Pod{}, gvkRule{Version: "v1", Kind: "Pod"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(opts.PropagationPolicy).To(BeNil())
+		Expect(opts.GracePeriodSeconds).To(BeNil())
+	})
+
+	It("should use the kind's propagation policy and grace period unless annotated", func() {
+		rule := gvkRule{Group: "apps", Version: "v1", Kind: "Deployment",
+			propagationPolicy: metav1.DeletePropagationForeground, gracePeriod: ptr.To[int64](30)}
+		obj := &corev1.Pod{}
+
+		opts, err := applied(obj, rule)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(*opts.PropagationPolicy).To(Equal(metav1.DeletePropagationForeground))
+		Expect(*opts.GracePeriodSeconds).To(Equal(int64(30)))
+
+		obj.SetAnnotations(map[string]string{
+			PropagationPolicyAnnotation: "Orphan",
+			GracePeriodAnnotation:       "0",
+		})
+		opts, err = applied(obj, rule)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(*opts.PropagationPolicy).To(Equal(metav1.DeletePropagationOrphan))
+		Expect(*opts.GracePeriodSeconds).To(Equal(int64(0)))
+	})
+
+	It("should reject invalid annotations", func() {
+		rule := gvkRule{Version: "v1", Kind: "Pod"}
+		for annotation, value := range map[string]string{
+			PropagationPolicyAnnotation: "cascade",
+			GracePeriodAnnotation:       "-1",
+		} {
+			obj := &corev1.Pod{}
+			obj.SetAnnotations(map[string]string{annotation: value})
+			_, err := deleteOptions(obj, rule)
+			Expect(err).To(HaveOccurred(), annotation)
+		}
+	})
+
+	It("should convert the propagation-policy and grace-period-seconds of the gvk-list", func() {
+		config, err := configFromConfigMap(map[string]string{
+			"check-interval": "5m",
+			"gvk-list": `- group: "apps"
+  version: "v1"
+  kind: "Deployment"
+  propagation-policy: "foreground"
+- version: "v1"
+  kind: "Pod"
+  grace-period-seconds: 5`,
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(config.rules[0].propagationPolicy).To(Equal(metav1.DeletePropagationForeground))
+		Expect(config.rules[0].gracePeriod).To(BeNil())
+		Expect(config.rules[1].propagationPolicy).To(BeEmpty())
+		Expect(*config.rules[1].gracePeriod).To(Equal(int64(5)))
+
+		_, err = configFromConfigMap(map[string]string{
+			"check-interval": "5m",
+			"gvk-list": `- version: "v1"
+  kind: "Pod"
+  grace-period-seconds: -5`,
+		})
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,59 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/events"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+// eventsComponent reports the events of the operator
+const eventsComponent = "kubettlreaper-controller"
+
+// Actions of the events, what the operator was doing when the event occurred
+const (
+	eventActionConfigure = "Configure"
+	eventActionSweep     = "Sweep"
+	eventActionMigrate   = "Migrate"
+	eventActionReap      = "Reap"
+)
+
+// NewEventRecorder returns an events.k8s.io/v1 recorder for the manager, repeats of an
+// event are aggregated into a series rather than each creating an event
+func NewEventRecorder(mgr manager.Manager) (events.EventRecorder, error) {
+	clientset, err := kubernetes.NewForConfig(mgr.GetConfig())
+	if err != nil {
+		return nil, err
+	}
+	broadcaster := events.NewBroadcaster(&events.EventSinkImpl{Interface: clientset.EventsV1()})
+
+	// Record for as long as the manager runs
+	err = mgr.Add(manager.RunnableFunc(func(ctx context.Context) error {
+		broadcaster.StartRecordingToSink(ctx.Done())
+		<-ctx.Done()
+		broadcaster.Shutdown()
+		return nil
+	}))
+	if err != nil {
+		return nil, err
+	}
+
+	return broadcaster.NewRecorder(mgr.GetScheme(), eventsComponent), nil
+}
@@ -0,0 +1,198 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"kubettlreaper/internal/ttl"
+)
+
+// renewalClockSkew is how far in the future a renewed-at annotation may be, for clock skew
+// between the operator and whoever stamps it
+const renewalClockSkew = time.Minute
+
+// errTtlNotStarted is returned when the TTL start point hasn't happened yet,
+// e.g. the status condition it is anchored to isn't true
+var errTtlNotStarted = errors.New("TTL countdown has not started")
+
+// hasExpiry reports whether the object carries a TTL label or an expires-at annotation
+func hasExpiry(obj client.Object) bool {
+	if _, exists := obj.GetLabels()[TtlLabel]; exists {
+		return true
+	}
+	_, exists := obj.GetAnnotations()[ExpiresAtAnnotation]
+	return exists
+}
+
+// hasExpiryFor reports whether the object expires under the rule,
+// tenant rules can give objects without a TTL a default one
+func hasExpiryFor(obj client.Object, rule gvkRule) bool {
+	return rule.defaultTtl > 0 || hasExpiry(obj)
+}
+
+// getExpirationTime works out when an object expires.
+// The expires-at annotation is an absolute deadline and takes precedence over the TTL label,
+// which is ignored when both are set. The TTL label is relative to the ttl-start anchor, or
+// to the renewed-at annotation when that is newer, capped at creation + max-lifetime.
+// Objects with neither get the default TTL of a tenant rule.
+func getExpirationTime(obj client.Object, rule gvkRule) (time.Time, error) {
+	if expiresAt, exists := obj.GetAnnotations()[ExpiresAtAnnotation]; exists {
+		deadline, err := ttl.ParseTimestamp(expiresAt)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid %s annotation: %w", ExpiresAtAnnotation, err)
+		}
+		return deadline, nil
+	}
+
+	ttlDuration := rule.defaultTtl
+	if ttlValue, exists := obj.GetLabels()[TtlLabel]; exists {
+		var err error
+		if ttlDuration, err = ttl.ParseDuration(ttlValue); err != nil {
+			return time.Time{}, fmt.Errorf("invalid %s label: %w", TtlLabel, err)
+		}
+	} else if ttlDuration == 0 {
+		return time.Time{}, fmt.Errorf("neither %s label nor %s annotation is set", TtlLabel, ExpiresAtAnnotation)
+	}
+
+	anchor, err := getAnchorTime(obj, rule)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	expirationTime := anchor.Add(ttlDuration)
+	if rule.maxLifetime > 0 {
+		lifetimeEnd := obj.GetCreationTimestamp().Time.Add(rule.maxLifetime)
+		if lifetimeEnd.Before(expirationTime) {
+			expirationTime = lifetimeEnd
+		}
+	}
+
+	return expirationTime, nil
+}
+
+// getAnchorTime returns the time the TTL label counts down from, the ttl-start anchor or the
+// renewed-at annotation when that is newer
+func getAnchorTime(obj client.Object, rule gvkRule) (time.Time, error) {
+	anchor, err := getTtlStartTime(obj, rule.TtlStart)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	// A heartbeat restarts the countdown
+	if renewedAt, exists := obj.GetAnnotations()[RenewedAtAnnotation]; exists {
+		renewedTime, err := ttl.ParseTimestamp(renewedAt)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid %s annotation: %w", RenewedAtAnnotation, err)
+		}
+		// A renewal in the future would keep the object alive until then and beyond
+		if renewedTime.After(time.Now().Add(renewalClockSkew)) {
+			return time.Time{}, fmt.Errorf("invalid %s annotation: %s is in the future", RenewedAtAnnotation, renewedAt)
+		}
+		if renewedTime.After(anchor) {
+			anchor = renewedTime
+		}
+	}
+	return anchor, nil
+}
+
+// getTtlStartTime returns the time the TTL counts down from,
+// condition start points need the full object rather than just its metadata
+func getTtlStartTime(obj client.Object, ttlStart string) (time.Time, error) {
+	creationTime := obj.GetCreationTimestamp().Time
+
+	switch ttlStart {
+	case "", TtlStartCreation:
+		return creationTime, nil
+	case TtlStartLabel:
+		// Earliest write by a field manager owning the TTL label, managedFields only
+		// record the last operation per manager so this is a best effort
+		var labelTime time.Time
+		for _, entry := range obj.GetManagedFields() {
+			if entry.Time == nil || entry.FieldsV1 == nil || !ownsLabel(entry.FieldsV1.Raw, TtlLabel) {
+				continue
+			}
+			if labelTime.IsZero() || entry.Time.Time.Before(labelTime) {
+				labelTime = entry.Time.Time
+			}
+		}
+		if labelTime.IsZero() {
+			return creationTime, nil
+		}
+		return labelTime, nil
+	case TtlStartLastUpdate:
+		// The reaper's own writes, e.g. expiry warnings or actions, aren't updates
+		lastUpdate := creationTime
+		for _, entry := range obj.GetManagedFields() {
+			if entry.Manager == FieldOwner {
+				continue
+			}
+			if entry.Time != nil && entry.Time.Time.After(lastUpdate) {
+				lastUpdate = entry.Time.Time
+			}
+		}
+		return lastUpdate, nil
+	}
+
+	conditionType := strings.TrimPrefix(ttlStart, TtlStartConditionPrefix)
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return time.Time{}, fmt.Errorf("ttl-start %s needs the full object", ttlStart)
+	}
+	conditions, _, err := unstructured.NestedSlice(u.Object, "status", "conditions")
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid status.conditions: %w", err)
+	}
+	for _, c := range conditions {
+		condition, ok := c.(map[string]interface{})
+		if !ok || condition["type"] != conditionType {
+			continue
+		}
+		if condition["status"] != "True" {
+			return time.Time{}, errTtlNotStarted
+		}
+		transition, _ := condition["lastTransitionTime"].(string)
+		transitionTime, err := time.Parse(time.RFC3339, transition)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid lastTransitionTime on condition %s: %w", conditionType, err)
+		}
+		return transitionTime, nil
+	}
+
+	return time.Time{}, errTtlNotStarted
+}
+
+// ownsLabel reports whether a managedFields FieldsV1 set contains the label
+func ownsLabel(raw []byte, label string) bool {
+	var fields struct {
+		Metadata struct {
+			Labels map[string]json.RawMessage `json:"f:labels"`
+		} `json:"f:metadata"`
+	}
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return false
+	}
+	_, exists := fields.Metadata.Labels["f:"+label]
+	return exists
+}
@@ -0,0 +1,201 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	"github.com/go-logr/logr"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+// expiryRequest identifies an object of a configured kind to check for expiry
+type expiryRequest struct {
+	GVK schema.GroupVersionKind
+	types.NamespacedName
+}
+
+// expiryReconciler reaps single objects at their exact expiry time by requeueing each
+// object until it expires. The periodic sweep is kept as a safety net.
+type expiryReconciler struct {
+	reaper *TtlReaperReconciler
+}
+
+// Reconcile checks a single object and reaps it if expired, otherwise requeues it for its expiry
+func (r *expiryReconciler) Reconcile(ctx context.Context, req expiryRequest) (ctrl.Result, error) {
+	obj := &metav1.PartialObjectMetadata{}
+	obj.SetGroupVersionKind(req.GVK)
+	if err := r.reaper.watchedCache(req.GVK).Get(ctx, req.NamespacedName, obj); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	rule, _, ok := r.reaper.getRule(ctx, req.GVK, obj)
+	if !ok {
+		// No configuration claims the object, e.g. the kind was removed from the
+		// configurations and watches can't be removed, so ignore it
+		return ctrl.Result{}, nil
+	}
+
+	if !hasExpiryFor(obj, rule) {
+		return ctrl.Result{}, nil
+	}
+	if matches, err := r.reaper.matchesFieldSelector(ctx, obj, rule); err != nil || !matches {
+		return ctrl.Result{}, err
+	}
+
+	_, remaining, err := r.reaper.reap(ctx, obj, rule)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// Come back at the next warning threshold, or at the expiry
+	if nextWarning, pending := untilNextWarning(rule.warnBefore, remaining); pending {
+		remaining = nextWarning
+	}
+	return ctrl.Result{RequeueAfter: remaining}, nil
+}
+
+// setupExpiryController creates the controller that per-kind watches are added to
+func (r *TtlReaperReconciler) setupExpiryController(mgr ctrl.Manager) error {
+	name := "ttlreaper-expiry"
+	logger := mgr.GetLogger().WithValues("controller", name)
+
+	c, err := controller.NewTyped(name, mgr, controller.TypedOptions[expiryRequest]{
+		Reconciler: &expiryReconciler{reaper: r},
+		LogConstructor: func(req *expiryRequest) logr.Logger {
+			if req == nil {
+				return logger
+			}
+			return logger.WithValues("gvk", req.GVK.String(), "object", klog.KRef(req.Namespace, req.Name))
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Kinds are watched in a cache of the objects with the TTL label, so the objects without
+	// an expiry aren't cached, except for kinds listing unlabeled objects
+	labeledCache, err := cache.New(mgr.GetConfig(), cache.Options{
+		HTTPClient:           mgr.GetHTTPClient(),
+		Scheme:               mgr.GetScheme(),
+		Mapper:               mgr.GetRESTMapper(),
+		DefaultLabelSelector: labels.NewSelector().Add(*ttlLabelRequirement),
+	})
+	if err != nil {
+		return err
+	}
+	if err := mgr.Add(labeledCache); err != nil {
+		return err
+	}
+
+	r.expiryController = c
+	r.cache = mgr.GetCache()
+	r.labeledCache = labeledCache
+	r.restMapper = mgr.GetRESTMapper()
+
+	return nil
+}
+
+// watchedCache returns the cache a kind is watched in
+func (r *TtlReaperReconciler) watchedCache(gvk schema.GroupVersionKind) cache.Cache {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.watched[gvk]
+}
+
+// watchKinds starts metadata-only watches on objects with an expiry for any newly configured
+// kinds, only on those with the TTL label unless the kind lists unlabeled objects. The cache
+// is picked when the kind is first watched, as watches can't be removed
+func (r *TtlReaperReconciler) watchKinds(ctx context.Context, rules []gvkRule) {
+	l := log.FromContext(ctx)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, rule := range rules {
+		gvk := rule.GroupVersionKind()
+		if _, watched := r.watched[gvk]; watched {
+			continue
+		}
+
+		// Don't start an informer for a kind the API server doesn't serve, e.g. a CRD not yet installed
+		if _, err := r.restMapper.RESTMapping(gvk.GroupKind(), gvk.Version); err != nil {
+			l.Error(err, "Unable to watch kind, relying on the periodic sweep", "gvk", gvk.String())
+			continue
+		}
+
+		kindCache := r.labeledCache
+		if rule.listUnlabeled {
+			kindCache = r.cache
+		}
+		obj := &metav1.PartialObjectMetadata{}
+		obj.SetGroupVersionKind(gvk)
+		src := source.TypedKind(kindCache, obj,
+			handler.TypedEnqueueRequestsFromMapFunc(
+				func(_ context.Context, o *metav1.PartialObjectMetadata) []expiryRequest {
+					return []expiryRequest{{GVK: gvk, NamespacedName: client.ObjectKeyFromObject(o)}}
+				}),
+			r.expiryPredicate(gvk),
+		)
+		if err := r.expiryController.Watch(src); err != nil {
+			l.Error(err, "Unable to watch kind, relying on the periodic sweep", "gvk", gvk.String())
+			continue
+		}
+
+		if r.watched == nil {
+			r.watched = map[schema.GroupVersionKind]cache.Cache{}
+		}
+		r.watched[gvk] = kindCache
+		l.Info("Watching kind for expiry", "gvk", gvk.String())
+	}
+}
+
+// expiryPredicate only passes objects with an expiry, deletes need no action. Predicates
+// outlive the reconcile that started the watch so they don't share its context
+func (r *TtlReaperReconciler) expiryPredicate(gvk schema.GroupVersionKind) predicate.TypedPredicate[*metav1.PartialObjectMetadata] {
+	expires := func(obj *metav1.PartialObjectMetadata) bool {
+		rule, _, ok := r.getRule(context.Background(), gvk, obj)
+		return ok && hasExpiryFor(obj, rule)
+	}
+	return predicate.TypedFuncs[*metav1.PartialObjectMetadata]{
+		CreateFunc: func(e event.TypedCreateEvent[*metav1.PartialObjectMetadata]) bool {
+			return expires(e.Object)
+		},
+		UpdateFunc: func(e event.TypedUpdateEvent[*metav1.PartialObjectMetadata]) bool {
+			return expires(e.ObjectNew)
+		},
+		DeleteFunc: func(event.TypedDeleteEvent[*metav1.PartialObjectMetadata]) bool {
+			return false
+		},
+		GenericFunc: func(e event.TypedGenericEvent[*metav1.PartialObjectMetadata]) bool {
+			return expires(e.Object)
+		},
+	}
+}
@@ -0,0 +1,188 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+var _ = Describe("Expiry calculation", func() {
+	created := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	labelled := created.Add(30 * 24 * time.Hour)
+	updated := labelled.Add(time.Hour)
+
+	newObject := func() *unstructured.Unstructured {
+		obj := &unstructured.Unstructured{Object: map[string]interface{}{}}
+		obj.SetCreationTimestamp(metav1.NewTime(created))
+		obj.SetLabels(map[string]string{TtlLabel: "1h"})
+		obj.SetManagedFields([]metav1.ManagedFieldsEntry{
+			{
+				Manager:  "kubectl-create",
+				Time:     &metav1.Time{Time: created},
+				FieldsV1: &metav1.FieldsV1{Raw: []byte(`{"f:spec":{}}`)},
+			},
+			{
+				Manager: "kubectl-label",
+				Time:    &metav1.Time{Time: labelled},
+				FieldsV1: &metav1.FieldsV1{
+					Raw: []byte(`{"f:metadata":{"f:labels":{"f:kubettlreaper.samir.io/ttl":{}}}}`),
+				},
+			},
+			{
+				Manager:  "kubectl-edit",
+				Time:     &metav1.Time{Time: updated},
+				FieldsV1: &metav1.FieldsV1{Raw: []byte(`{"f:data":{}}`)},
+			},
+		})
+		return obj
+	}
+
+	It("should count down from creation by default", func() {
+		expiry, err := getExpirationTime(newObject(), gvkRule{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(expiry).To(BeTemporally("==", created.Add(time.Hour)))
+	})
+
+	It("should count down from when the TTL label was applied", func() {
+		expiry, err := getExpirationTime(newObject(), gvkRule{TtlStart: TtlStartLabel})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(expiry).To(BeTemporally("==", labelled.Add(time.Hour)))
+	})
+
+	It("should fall back to creation when no manager owns the TTL label", func() {
+		obj := newObject()
+		obj.SetManagedFields(nil)
+		expiry, err := getExpirationTime(obj, gvkRule{TtlStart: TtlStartLabel})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(expiry).To(BeTemporally("==", created.Add(time.Hour)))
+	})
+
+	It("should count down from the last update", func() {
+		expiry, err := getExpirationTime(newObject(), gvkRule{TtlStart: TtlStartLastUpdate})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(expiry).To(BeTemporally("==", updated.Add(time.Hour)))
+	})
+
+	It("should not count the reaper's own writes as updates", func() {
+		obj := newObject()
+		obj.SetManagedFields(append(obj.GetManagedFields(), metav1.ManagedFieldsEntry{
+			Manager:  FieldOwner,
+			Time:     &metav1.Time{Time: updated.Add(30 * time.Minute)},
+			FieldsV1: &metav1.FieldsV1{Raw: []byte(`{"f:metadata":{"f:annotations":{"f:kubettlreaper.samir.io/actioned-at":{}}}}`)},
+		}))
+		expiry, err := getExpirationTime(obj, gvkRule{TtlStart: TtlStartLastUpdate})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(expiry).To(BeTemporally("==", updated.Add(time.Hour)))
+	})
+
+	It("should count down from a true status condition", func() {
+		completed := updated.Add(time.Hour)
+		obj := newObject()
+		Expect(unstructured.SetNestedSlice(obj.Object, []interface{}{
+			map[string]interface{}{
+				"type":               "Complete",
+				"status":             "True",
+				"lastTransitionTime": completed.Format(time.RFC3339),
+			},
+		}, "status", "conditions")).To(Succeed())
+		expiry, err := getExpirationTime(obj, gvkRule{TtlStart: TtlStartConditionPrefix + "Complete"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(expiry).To(BeTemporally("==", completed.Add(time.Hour)))
+	})
+
+	It("should not start the countdown until the status condition is true", func() {
+		obj := newObject()
+		_, err := getExpirationTime(obj, gvkRule{TtlStart: TtlStartConditionPrefix + "Complete"})
+		Expect(err).To(MatchError(errTtlNotStarted))
+
+		Expect(unstructured.SetNestedSlice(obj.Object, []interface{}{
+			map[string]interface{}{"type": "Complete", "status": "False"},
+		}, "status", "conditions")).To(Succeed())
+		_, err = getExpirationTime(obj, gvkRule{TtlStart: TtlStartConditionPrefix + "Complete"})
+		Expect(err).To(MatchError(errTtlNotStarted))
+	})
+
+	It("should ignore the TTL start when an expires-at annotation is set", func() {
+		obj := newObject()
+		obj.SetAnnotations(map[string]string{ExpiresAtAnnotation: "2024-06-01T00:00:00Z"})
+		expiry, err := getExpirationTime(obj, gvkRule{TtlStart: TtlStartLastUpdate})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(expiry).To(BeTemporally("==", time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)))
+	})
+
+	It("should restart the countdown when renewed", func() {
+		renewed := updated.Add(24 * time.Hour)
+		obj := newObject()
+		obj.SetAnnotations(map[string]string{RenewedAtAnnotation: renewed.Format(time.RFC3339)})
+		expiry, err := getExpirationTime(obj, gvkRule{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(expiry).To(BeTemporally("==", renewed.Add(time.Hour)))
+	})
+
+	It("should ignore a renewal older than the TTL start", func() {
+		obj := newObject()
+		obj.SetAnnotations(map[string]string{RenewedAtAnnotation: created.Format(time.RFC3339)})
+		expiry, err := getExpirationTime(obj, gvkRule{TtlStart: TtlStartLastUpdate})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(expiry).To(BeTemporally("==", updated.Add(time.Hour)))
+	})
+
+	It("should cap renewals at the max lifetime", func() {
+		obj := newObject()
+		obj.SetAnnotations(map[string]string{RenewedAtAnnotation: updated.Format(time.RFC3339)})
+		expiry, err := getExpirationTime(obj, gvkRule{maxLifetime: 7 * 24 * time.Hour})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(expiry).To(BeTemporally("==", created.Add(7*24*time.Hour)))
+	})
+
+	It("should reject an invalid renewal", func() {
+		obj := newObject()
+		obj.SetAnnotations(map[string]string{RenewedAtAnnotation: "yesterday"})
+		_, err := getExpirationTime(obj, gvkRule{})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should reject a renewal in the future", func() {
+		obj := newObject()
+		obj.SetAnnotations(map[string]string{RenewedAtAnnotation: time.Now().Add(24 * time.Hour).Format(time.RFC3339)})
+		_, err := getExpirationTime(obj, gvkRule{})
+		Expect(err).To(MatchError(ContainSubstring("in the future")))
+
+		By("tolerating clock skew")
+		obj.SetAnnotations(map[string]string{RenewedAtAnnotation: time.Now().Add(10 * time.Second).Format(time.RFC3339)})
+		_, err = getExpirationTime(obj, gvkRule{})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("should apply a tenant default TTL to objects without a TTL label", func() {
+		obj := newObject()
+		obj.SetLabels(nil)
+		expiry, err := getExpirationTime(obj, gvkRule{defaultTtl: 2 * time.Hour})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(expiry).To(BeTemporally("==", created.Add(2*time.Hour)))
+
+		By("preferring the TTL label")
+		expiry, err = getExpirationTime(newObject(), gvkRule{defaultTtl: 2 * time.Hour})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(expiry).To(BeTemporally("==", created.Add(time.Hour)))
+	})
+})
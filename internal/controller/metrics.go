@@ -0,0 +1,179 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const metricsNamespace = "kubettlreaper"
+
+// Time to expiry buckets of the pending gauge, the last bucket is anything beyond
+var pendingBuckets = []struct {
+	label string
+	upTo  time.Duration
+}{
+	{"1h", time.Hour},
+	{"24h", 24 * time.Hour},
+	{"7d", 7 * 24 * time.Hour},
+	{"+Inf", 0},
+}
+
+var (
+	reapedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "reaped_total",
+		Help:      "Number of expired objects deleted",
+	}, []string{"gvk", "namespace"})
+
+	wouldReapTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "would_reap_total",
+		Help:      "Number of expired objects not deleted because of dry run",
+	}, []string{"gvk", "namespace"})
+
+	reapFailedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "reap_failed_total",
+		Help:      "Number of expired objects that failed to be deleted",
+	}, []string{"gvk", "namespace"})
+
+	archivedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "archived_total",
+		Help:      "Number of expired objects archived before being deleted",
+	}, []string{"gvk", "namespace"})
+
+	invalidTtlTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "invalid_ttl_total",
+		Help:      "Number of objects skipped because of an invalid TTL label or annotation",
+	}, []string{"gvk", "namespace"})
+
+	conditionNotMetTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "condition_not_met_total",
+		Help:      "Number of expired objects not deleted because their condition doesn't hold",
+	}, []string{"gvk", "namespace"})
+
+	actionsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "actions_total",
+		Help:      "Number of actions other than delete taken on expired objects",
+	}, []string{"gvk", "namespace", "action"})
+
+	actionFailedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "action_failed_total",
+		Help:      "Number of actions other than delete that failed on expired objects",
+	}, []string{"gvk", "namespace", "action"})
+
+	notificationFailedTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "notification_failed_total",
+		Help:      "Number of notifications that failed to be sent",
+	})
+
+	notificationDroppedTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "notification_dropped_total",
+		Help:      "Number of notifications dropped because the notification queue was full",
+	})
+
+	auditFailedTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "audit_failed_total",
+		Help:      "Number of audit records that failed to be written",
+	})
+
+	sweepDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "sweep_duration_seconds",
+		Help:      "Duration of the periodic sweep of a GVK",
+		Buckets:   prometheus.ExponentialBuckets(0.05, 2, 14),
+	}, []string{"configuration", "gvk"})
+
+	pendingExpiry = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "pending_expiry",
+		Help:      "Number of objects yet to expire as of the last sweep, by time to expiry (le)",
+	}, []string{"configuration", "gvk", "le"})
+
+	pendingDeletion = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "pending_deletion",
+		Help:      "Number of expired objects whose deletion hadn't completed as of the last sweep",
+	}, []string{"configuration", "gvk"})
+
+	lastSuccessfulSweep = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "last_successful_sweep_timestamp_seconds",
+		Help:      "Unix time of the last successful sweep of a GVK",
+	}, []string{"configuration", "gvk"})
+)
+
+func init() {
+	// Served by the manager's metrics server alongside the controller-runtime metrics
+	metrics.Registry.MustRegister(
+		reapedTotal,
+		wouldReapTotal,
+		reapFailedTotal,
+		archivedTotal,
+		invalidTtlTotal,
+		conditionNotMetTotal,
+		actionsTotal,
+		actionFailedTotal,
+		notificationFailedTotal,
+		notificationDroppedTotal,
+		auditFailedTotal,
+		sweepDuration,
+		pendingExpiry,
+		pendingDeletion,
+		lastSuccessfulSweep,
+	)
+}
+
+// gvkLabel formats a GVK as a metric label, e.g. apps/v1/Deployment or v1/Pod
+func gvkLabel(gvk schema.GroupVersionKind) string {
+	return gvk.GroupVersion().String() + "/" + gvk.Kind
+}
+
+// pendingCounts counts objects yet to expire by time to expiry bucket
+type pendingCounts [4]int
+
+// add counts an object expiring in remaining
+func (p *pendingCounts) add(remaining time.Duration) {
+	for i, bucket := range pendingBuckets {
+		if bucket.upTo == 0 || remaining <= bucket.upTo {
+			p[i]++
+			return
+		}
+	}
+}
+
+// record sets the pending gauge of a GVK of a configuration, buckets are cumulative like a histogram
+func (p *pendingCounts) record(configuration string, gvk schema.GroupVersionKind) {
+	cumulative := 0
+	for i, bucket := range pendingBuckets {
+		cumulative += p[i]
+		pendingExpiry.WithLabelValues(configuration, gvkLabel(gvk), bucket.label).Set(float64(cumulative))
+	}
+}
@@ -0,0 +1,50 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+var _ = Describe("Metrics", func() {
+	It("should label GVKs by group version and kind", func() {
+		Expect(gvkLabel(schema.GroupVersionKind{Version: "v1", Kind: "Pod"})).To(Equal("v1/Pod"))
+		Expect(gvkLabel(schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"})).
+			To(Equal("apps/v1/Deployment"))
+	})
+
+	It("should bucket pending objects cumulatively by time to expiry", func() {
+		gvk := schema.GroupVersionKind{Group: "example.com", Version: "v1", Kind: "Pending"}
+		pending := pendingCounts{}
+		pending.add(time.Minute)
+		pending.add(time.Hour)
+		pending.add(2 * time.Hour)
+		pending.add(30 * 24 * time.Hour)
+		pending.record("metrics-test", gvk)
+
+		label := gvkLabel(gvk)
+		Expect(testutil.ToFloat64(pendingExpiry.WithLabelValues("metrics-test", label, "1h"))).To(Equal(2.0))
+		Expect(testutil.ToFloat64(pendingExpiry.WithLabelValues("metrics-test", label, "24h"))).To(Equal(3.0))
+		Expect(testutil.ToFloat64(pendingExpiry.WithLabelValues("metrics-test", label, "7d"))).To(Equal(3.0))
+		Expect(testutil.ToFloat64(pendingExpiry.WithLabelValues("metrics-test", label, "+Inf"))).To(Equal(4.0))
+	})
+})
@@ -0,0 +1,145 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// protectedNamespaces are never reaped in unless a configuration lists them by name
+func protectedNamespaces() []string {
+	protected := []string{"kube-system", "kube-public"}
+	if OperatorNamespace != "" {
+		protected = append(protected, OperatorNamespace)
+	}
+	return protected
+}
+
+// namespaceFilter limits a configuration to some namespaces, patterns are names or globs
+type namespaceFilter struct {
+	include         []string
+	includeSelector labels.Selector
+	exclude         []string
+	excludeSelector labels.Selector
+}
+
+// newNamespaceFilter validates the namespace patterns and selectors of a policy spec
+func newNamespaceFilter(include, exclude []string, includeSelector, excludeSelector *metav1.LabelSelector) (namespaceFilter, error) {
+	filter := namespaceFilter{include: include, exclude: exclude}
+	for _, pattern := range slices.Concat(include, exclude) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return filter, fmt.Errorf("invalid namespace pattern %q: %w", pattern, err)
+		}
+	}
+
+	var err error
+	if includeSelector != nil {
+		if filter.includeSelector, err = metav1.LabelSelectorAsSelector(includeSelector); err != nil {
+			return filter, fmt.Errorf("invalid namespace selector: %w", err)
+		}
+	}
+	if excludeSelector != nil {
+		if filter.excludeSelector, err = metav1.LabelSelectorAsSelector(excludeSelector); err != nil {
+			return filter, fmt.Errorf("invalid exclude namespace selector: %w", err)
+		}
+	}
+
+	return filter, nil
+}
+
+// needsLabels reports whether the filter selects namespaces by label
+func (f namespaceFilter) needsLabels() bool {
+	return f.includeSelector != nil || f.excludeSelector != nil
+}
+
+// allows reports whether objects in a namespace may be reaped. Protected namespaces have
+// to be included by name, a glob or selector is not enough. Exclusions take precedence
+// over inclusions. nsLabels is nil when the namespace labels are unknown, a filter with
+// selectors then refuses the namespace. Cluster-scoped objects are always allowed
+func (f namespaceFilter) allows(namespace string, nsLabels labels.Set) bool {
+	if namespace == "" {
+		return true
+	}
+	if slices.Contains(protectedNamespaces(), namespace) && !slices.Contains(f.include, namespace) {
+		return false
+	}
+	if f.needsLabels() && nsLabels == nil {
+		return false
+	}
+
+	if matchesNamespace(f.exclude, namespace) || (f.excludeSelector != nil && f.excludeSelector.Matches(nsLabels)) {
+		return false
+	}
+	if len(f.include) == 0 && f.includeSelector == nil {
+		return true
+	}
+	return matchesNamespace(f.include, namespace) || (f.includeSelector != nil && f.includeSelector.Matches(nsLabels))
+}
+
+// matchesNamespace reports whether a namespace matches any of the names or globs
+func matchesNamespace(patterns []string, namespace string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, namespace); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// splitList splits a comma separated list from the configMap, e.g. of namespaces
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// objectNamespace returns the namespace an object is guarded by, a Namespace is guarded by
+// its own name. Empty for other cluster-scoped objects, which namespace filters don't apply to
+func objectNamespace(gvk schema.GroupVersionKind, obj client.Object) string {
+	if gvk.Group == "" && gvk.Kind == "Namespace" {
+		return obj.GetName()
+	}
+	return obj.GetNamespace()
+}
+
+// namespaceLabels returns the labels of a namespace from the cache, nil if it can't be read
+func (r *TtlReaperReconciler) namespaceLabels(ctx context.Context, namespace string) labels.Set {
+	ns := &metav1.PartialObjectMetadata{}
+	ns.SetGroupVersionKind(schema.GroupVersionKind{Version: "v1", Kind: "Namespace"})
+	if err := r.Get(ctx, client.ObjectKey{Name: namespace}, ns); err != nil {
+		log.FromContext(ctx).Error(err, "Failed to read namespace labels", "namespace", namespace)
+		return nil
+	}
+	if nsLabels := labels.Set(ns.GetLabels()); nsLabels != nil {
+		return nsLabels
+	}
+	return labels.Set{}
+}
@@ -0,0 +1,82 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+var _ = Describe("Namespace filters", func() {
+	newFilter := func(include, exclude []string, includeSelector, excludeSelector *metav1.LabelSelector) namespaceFilter {
+		filter, err := newNamespaceFilter(include, exclude, includeSelector, excludeSelector)
+		Expect(err).NotTo(HaveOccurred())
+		return filter
+	}
+	preview := &metav1.LabelSelector{MatchLabels: map[string]string{"env": "preview"}}
+
+	It("should allow every namespace but the protected ones by default", func() {
+		filter := newFilter(nil, nil, nil, nil)
+		Expect(filter.allows("default", nil)).To(BeTrue())
+		Expect(filter.allows("kube-system", nil)).To(BeFalse())
+		Expect(filter.allows("kube-public", nil)).To(BeFalse())
+		Expect(filter.allows("", nil)).To(BeTrue())
+	})
+
+	It("should only opt into a protected namespace by name", func() {
+		Expect(newFilter([]string{"kube-*"}, nil, nil, nil).allows("kube-system", nil)).To(BeFalse())
+		Expect(newFilter(nil, nil, &metav1.LabelSelector{}, nil).allows("kube-system", labels.Set{})).To(BeFalse())
+		Expect(newFilter([]string{"kube-system"}, nil, nil, nil).allows("kube-system", nil)).To(BeTrue())
+	})
+
+	It("should match names and globs", func() {
+		filter := newFilter([]string{"ci", "preview-*"}, []string{"preview-keep"}, nil, nil)
+		Expect(filter.allows("ci", nil)).To(BeTrue())
+		Expect(filter.allows("preview-42", nil)).To(BeTrue())
+		Expect(filter.allows("preview-keep", nil)).To(BeFalse())
+		Expect(filter.allows("default", nil)).To(BeFalse())
+	})
+
+	It("should match namespace labels", func() {
+		filter := newFilter([]string{"ci"}, nil, preview, &metav1.LabelSelector{
+			MatchLabels: map[string]string{"kubettlreaper.samir.io/protected": "true"},
+		})
+		Expect(filter.allows("ci", labels.Set{})).To(BeTrue())
+		Expect(filter.allows("team-a", labels.Set{"env": "preview"})).To(BeTrue())
+		Expect(filter.allows("team-b", labels.Set{"env": "prod"})).To(BeFalse())
+		Expect(filter.allows("team-a", labels.Set{"env": "preview", "kubettlreaper.samir.io/protected": "true"})).
+			To(BeFalse())
+
+		By("refusing namespaces whose labels are unknown")
+		Expect(filter.allows("ci", nil)).To(BeFalse())
+	})
+
+	It("should reject an invalid glob", func() {
+		_, err := newNamespaceFilter([]string{"preview-["}, nil, nil, nil)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should guard a Namespace by its own name", func() {
+		ns := &metav1.PartialObjectMetadata{ObjectMeta: metav1.ObjectMeta{Name: "kube-system"}}
+		Expect(objectNamespace(schema.GroupVersionKind{Version: "v1", Kind: "Namespace"}, ns)).To(Equal("kube-system"))
+		Expect(objectNamespace(schema.GroupVersionKind{Group: "rbac.authorization.k8s.io", Version: "v1",
+			Kind: "ClusterRole"}, ns)).To(BeEmpty())
+	})
+})
@@ -0,0 +1,356 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"kubettlreaper/api/v1alpha1"
+	"kubettlreaper/internal/notify"
+)
+
+// OwnerAnnotation names who to notify about an object, e.g. a team or an email address,
+// it is passed through to the notifier as is
+const OwnerAnnotation = "kubettlreaper.samir.io/owner"
+
+// notificationQueueSize is how many sends, the batch of a sweep or a notification of the
+// expiry controller, wait to be dispatched, more are dropped
+const notificationQueueSize = 1000
+
+// newNotification returns a notification about an object of a rule
+func newNotification(notificationType string, obj client.Object, rule gvkRule, action v1alpha1.ExpiryAction,
+	expiresAt time.Time, result string, err error, message string) notify.Notification {
+	gvk := rule.GroupVersionKind()
+	notification := notify.Notification{
+		Type: notificationType,
+		Time: time.Now().UTC(),
+		Object: notify.ObjectReference{
+			APIVersion: gvk.GroupVersion().String(),
+			Kind:       gvk.Kind,
+			Namespace:  obj.GetNamespace(),
+			Name:       obj.GetName(),
+			UID:        obj.GetUID(),
+		},
+		GVK:     gvkLabel(gvk),
+		Owner:   obj.GetAnnotations()[OwnerAnnotation],
+		TTL:     obj.GetLabels()[TtlLabel],
+		Action:  string(action),
+		Result:  result,
+		Message: message,
+	}
+	if !expiresAt.IsZero() {
+		notification.ExpiresAt = ptr.To(expiresAt.UTC())
+	}
+	if err != nil {
+		notification.Error = err.Error()
+	}
+	return notification
+}
+
+// notificationBatch collects the notifications of a sweep so they are dispatched together
+type notificationBatch struct {
+	mu     sync.Mutex
+	queued []queuedNotification
+}
+
+type notificationBatchKey struct{}
+
+// withNotificationBatch returns a context whose notifications are batched until flushed
+func withNotificationBatch(ctx context.Context) (context.Context, *notificationBatch) {
+	batch := &notificationBatch{}
+	return context.WithValue(ctx, notificationBatchKey{}, batch), batch
+}
+
+// queuedNotification is a notification waiting to be dispatched
+type queuedNotification struct {
+	notification notify.Notification
+	// key identifies a notification delivered once per receiver, unset for the others
+	key deliveryKey
+	// sent is called once every receiver accepted a keyed notification
+	sent func(context.Context)
+}
+
+// deliveryKey identifies a notification delivered once per receiver, e.g. the warning about
+// a threshold of an object's expiry
+type deliveryKey struct {
+	uid types.UID
+	id  string
+}
+
+// deliveries tracks the receivers that accepted a keyed notification, so when some of them
+// fail it is only sent again to the others, and claims it while it is dispatched so the sweep
+// and the expiry controller don't both send it
+type deliveries struct {
+	mu       sync.Mutex
+	accepted map[deliveryKey]map[int]bool
+	claimed  map[deliveryKey]bool
+}
+
+// claim claims a notification before it is dispatched, false when it is already being
+// dispatched or was delivered. Unkeyed ones are always claimed
+func (d *deliveries) claim(key deliveryKey) bool {
+	if key == (deliveryKey{}) {
+		return true
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.claimed[key] {
+		return false
+	}
+	if d.claimed == nil {
+		d.claimed = map[deliveryKey]bool{}
+	}
+	d.claimed[key] = true
+	return true
+}
+
+// release releases the claim of a notification that wasn't delivered, so it is sent again
+func (d *deliveries) release(key deliveryKey) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.claimed, key)
+}
+
+// isAccepted reports whether a receiver accepted a notification
+func (d *deliveries) isAccepted(key deliveryKey, receiver int) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.accepted[key][receiver]
+}
+
+// accept records that a receiver accepted a notification, unkeyed ones aren't tracked
+func (d *deliveries) accept(key deliveryKey, receiver int) {
+	if key == (deliveryKey{}) {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.accepted == nil {
+		d.accepted = map[deliveryKey]map[int]bool{}
+	}
+	if d.accepted[key] == nil {
+		d.accepted[key] = map[int]bool{}
+	}
+	d.accepted[key][receiver] = true
+}
+
+// done reports whether all the receivers accepted a notification, it then stays claimed
+// until the object is forgotten
+func (d *deliveries) done(key deliveryKey, receivers int) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if key == (deliveryKey{}) || len(d.accepted[key]) < receivers {
+		return false
+	}
+	delete(d.accepted, key)
+	return true
+}
+
+// forget forgets the notifications about an object, e.g. once it is reaped
+func (d *deliveries) forget(uid types.UID) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for key := range d.accepted {
+		if key.uid == uid {
+			delete(d.accepted, key)
+		}
+	}
+	for key := range d.claimed {
+		if key.uid == uid {
+			delete(d.claimed, key)
+		}
+	}
+}
+
+// notify dispatches a notification when a notifier is configured, or adds it to the batch
+// of the sweep
+func (r *TtlReaperReconciler) notify(ctx context.Context, notification notify.Notification) {
+	r.notifyOnce(ctx, notification, deliveryKey{}, nil)
+}
+
+// notifyOnce is notify delivering a keyed notification once per receiver, sent is called once
+// every receiver accepted it, right away without a notifier. It is ignored while the same key
+// is being dispatched or once delivered, a receiver that failed gets it when notified again
+func (r *TtlReaperReconciler) notifyOnce(ctx context.Context, notification notify.Notification,
+	key deliveryKey, sent func(context.Context)) {
+	if !r.deliveries.claim(key) {
+		return
+	}
+	if r.Notifier == nil {
+		if sent != nil {
+			sent(ctx)
+		}
+		return
+	}
+	queued := queuedNotification{notification: notification, key: key, sent: sent}
+	if batch, ok := ctx.Value(notificationBatchKey{}).(*notificationBatch); ok {
+		batch.mu.Lock()
+		defer batch.mu.Unlock()
+		batch.queued = append(batch.queued, queued)
+		return
+	}
+	r.send(ctx, []queuedNotification{queued})
+}
+
+// flushNotifications sends the notifications of a sweep
+func (r *TtlReaperReconciler) flushNotifications(ctx context.Context, batch *notificationBatch) {
+	batch.mu.Lock()
+	queued := batch.queued
+	batch.queued = nil
+	batch.mu.Unlock()
+	r.send(ctx, queued)
+}
+
+// send queues notifications for the notification worker so a slow notifier doesn't hold up
+// reconciles, they are dropped when the queue is full. Without a worker they are dispatched
+// right away
+func (r *TtlReaperReconciler) send(ctx context.Context, queued []queuedNotification) {
+	if len(queued) == 0 {
+		return
+	}
+	if r.notifications == nil {
+		r.dispatch(ctx, queued)
+		return
+	}
+	select {
+	case r.notifications <- queued:
+	default:
+		log.FromContext(ctx).Info("Notification queue full, dropping notifications", "count", len(queued))
+		notificationDroppedTotal.Add(float64(len(queued)))
+		for _, q := range queued {
+			r.deliveries.release(q.key)
+		}
+	}
+}
+
+// dispatchQueued runs the notification worker, dispatching the queued notifications one
+// send at a time until the context is done
+func (r *TtlReaperReconciler) dispatchQueued(ctx context.Context) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case queued := <-r.notifications:
+			r.dispatch(ctx, queued)
+		}
+	}
+}
+
+// dispatch sends notifications to each receiver of the notifier, skipping the keyed ones it
+// already accepted, and calls sent of those every receiver accepted
+func (r *TtlReaperReconciler) dispatch(ctx context.Context, queued []queuedNotification) {
+	receivers := notify.Receivers(r.Notifier)
+	for i, receiver := range receivers {
+		var pending []queuedNotification
+		var notifications []notify.Notification
+		for _, q := range queued {
+			if !r.deliveries.isAccepted(q.key, i) {
+				pending = append(pending, q)
+				notifications = append(notifications, q.notification)
+			}
+		}
+		if len(notifications) == 0 {
+			continue
+		}
+		if err := receiver.Notify(ctx, notifications); err != nil {
+			log.FromContext(ctx).Error(err, "Failed to send notifications", "count", len(notifications))
+			notificationFailedTotal.Add(float64(len(notifications)))
+			continue
+		}
+		for _, q := range pending {
+			r.deliveries.accept(q.key, i)
+		}
+	}
+	for _, q := range queued {
+		if !r.deliveries.done(q.key, len(receivers)) {
+			r.deliveries.release(q.key)
+			continue
+		}
+		if q.sent != nil {
+			q.sent(ctx)
+		}
+	}
+}
+
+// outcomeNotices tracks the last outcome notified per object, so a failure or skip repeated
+// on every sweep is only notified when the object's outcome changes
+type outcomeNotices struct {
+	mu   sync.Mutex
+	last map[types.UID]string
+}
+
+// changed records the outcome of an object, true when it differs from the last one
+func (o *outcomeNotices) changed(uid types.UID, notificationType string) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.last == nil {
+		o.last = map[types.UID]string{}
+	}
+	if o.last[uid] == notificationType {
+		return false
+	}
+	o.last[uid] = notificationType
+	return true
+}
+
+// forget forgets the outcome of an object, e.g. once it is reaped or no longer expired
+func (o *outcomeNotices) forget(uid types.UID) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	delete(o.last, uid)
+}
+
+// forgetObject forgets what was notified about an object once it is reaped or gone
+func (r *TtlReaperReconciler) forgetObject(uid types.UID) {
+	r.notices.forget(uid)
+	r.deliveries.forget(uid)
+}
+
+// notifyOutcome notifies that an expired object was reaped or failed to be, failures
+// are only notified on the transition to failing and an object found gone is forgotten
+func (r *TtlReaperReconciler) notifyOutcome(ctx context.Context, obj client.Object, rule gvkRule,
+	action v1alpha1.ExpiryAction, expiresAt time.Time, err error, message string) {
+	switch {
+	case apierrors.IsNotFound(err):
+		r.forgetObject(obj.GetUID())
+		return
+	case err == nil:
+		r.forgetObject(obj.GetUID())
+		r.notify(ctx, newNotification(notify.TypeReaped, obj, rule, action, expiresAt, notify.ResultSucceeded, nil, message))
+		return
+	}
+	if r.notices.changed(obj.GetUID(), notify.TypeFailed) {
+		r.notify(ctx, newNotification(notify.TypeFailed, obj, rule, action, expiresAt, notify.ResultFailed, err, message))
+	}
+}
+
+// notifySkipped notifies that an expired object was left alone, e.g. its condition doesn't
+// hold, its TTL is invalid or in dry run, only on the transition to skipped
+func (r *TtlReaperReconciler) notifySkipped(ctx context.Context, obj client.Object, rule gvkRule,
+	action v1alpha1.ExpiryAction, expiresAt time.Time, err error, message string) {
+	if r.notices.changed(obj.GetUID(), notify.TypeSkipped) {
+		r.notify(ctx, newNotification(notify.TypeSkipped, obj, rule, action, expiresAt, notify.ResultSkipped, err, message))
+	}
+}
@@ -0,0 +1,175 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"kubettlreaper/api/v1alpha1"
+)
+
+// fetchConfiguration returns the TtlReaperPolicy of a configuration, or the ConfigMap of the
+// same name in the operator namespace when there is no policy. Objects that are neither named
+// by --configuration-name nor carry the marker label are NotFound
+func (r *TtlReaperReconciler) fetchConfiguration(ctx context.Context, name string) (*v1alpha1.TtlReaperPolicy, *corev1.ConfigMap, error) {
+	if r.policyEnabled {
+		policy := &v1alpha1.TtlReaperPolicy{}
+		err := r.Get(ctx, client.ObjectKey{Name: name}, policy)
+		if err == nil && r.isConfiguration(policy) {
+			// The client drops the type, events need it
+			policy.SetGroupVersionKind(v1alpha1.GroupVersion.WithKind("TtlReaperPolicy"))
+			return policy, nil, nil
+		}
+		if err != nil && !apierrors.IsNotFound(err) && !meta.IsNoMatchError(err) {
+			return nil, nil, err
+		}
+	}
+
+	configMap := &corev1.ConfigMap{}
+	err := r.Get(ctx, client.ObjectKey{
+		Namespace: OperatorNamespace,
+		Name:      name,
+	}, configMap)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !r.isConfiguration(configMap) {
+		return nil, nil, apierrors.NewNotFound(corev1.Resource("configmaps"), name)
+	}
+
+	return nil, configMap, nil
+}
+
+// migrateConfiguration creates a TtlReaperPolicy from the ConfigMap spec, the
+// policy then takes over and the ConfigMap can be deleted
+func (r *TtlReaperReconciler) migrateConfiguration(ctx context.Context, configMap *corev1.ConfigMap, spec v1alpha1.TtlReaperPolicySpec) {
+	l := log.FromContext(ctx)
+	if !r.policyEnabled {
+		l.Info("TtlReaperPolicy CRD not installed, unable to migrate the ConfigMap")
+		return
+	}
+
+	policy := &v1alpha1.TtlReaperPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: configMap.Name},
+		Spec:       spec,
+	}
+	// Keep a discovered configuration discovered
+	if marker, exists := configMap.Labels[ConfigurationLabel]; exists {
+		policy.Labels = map[string]string{ConfigurationLabel: marker}
+	}
+	if err := r.Create(ctx, policy); err != nil {
+		if !apierrors.IsAlreadyExists(err) {
+			l.Error(err, "Failed to migrate ConfigMap to TtlReaperPolicy")
+		}
+		return
+	}
+
+	l.Info("Migrated ConfigMap to TtlReaperPolicy", "policy", policy.Name)
+	policy.SetGroupVersionKind(v1alpha1.GroupVersion.WithKind("TtlReaperPolicy"))
+	r.raiseEvent(policy, "Normal", "Migrated", eventActionMigrate, fmt.Sprintf("Created from ConfigMap %s/%s", OperatorNamespace, configMap.Name))
+}
+
+// updatePolicyStatus sets the policy conditions and the summary of the last sweep,
+// a nil summary leaves the last sweep untouched
+func (r *TtlReaperReconciler) updatePolicyStatus(ctx context.Context, policy *v1alpha1.TtlReaperPolicy,
+	invalidErr error, summary *v1alpha1.SweepSummary, sweepErr error) {
+	l := log.FromContext(ctx)
+	patch := client.MergeFrom(policy.DeepCopy())
+	generation := policy.GetGeneration()
+	policy.Status.ObservedGeneration = generation
+
+	if invalidErr != nil {
+		meta.SetStatusCondition(&policy.Status.Conditions, metav1.Condition{
+			Type:               v1alpha1.ConditionValid,
+			Status:             metav1.ConditionFalse,
+			Reason:             "InvalidSpec",
+			Message:            invalidErr.Error(),
+			ObservedGeneration: generation,
+		})
+	} else {
+		meta.SetStatusCondition(&policy.Status.Conditions, metav1.Condition{
+			Type:               v1alpha1.ConditionValid,
+			Status:             metav1.ConditionTrue,
+			Reason:             "ValidSpec",
+			Message:            "Policy spec is valid",
+			ObservedGeneration: generation,
+		})
+	}
+
+	if summary != nil {
+		policy.Status.LastSweepSummary = summary
+		if sweepErr != nil {
+			meta.SetStatusCondition(&policy.Status.Conditions, metav1.Condition{
+				Type:               v1alpha1.ConditionLastSweep,
+				Status:             metav1.ConditionFalse,
+				Reason:             "SweepFailed",
+				Message:            fmt.Sprintf("Failed to sweep %d kind(s)", len(summary.FailedKinds)),
+				ObservedGeneration: generation,
+			})
+			meta.SetStatusCondition(&policy.Status.Conditions, metav1.Condition{
+				Type:               v1alpha1.ConditionErrors,
+				Status:             metav1.ConditionTrue,
+				Reason:             "SweepFailed",
+				Message:            sweepErr.Error(),
+				ObservedGeneration: generation,
+			})
+		} else {
+			meta.SetStatusCondition(&policy.Status.Conditions, metav1.Condition{
+				Type:               v1alpha1.ConditionLastSweep,
+				Status:             metav1.ConditionTrue,
+				Reason:             "SweepSucceeded",
+				Message:            fmt.Sprintf("Swept %d kind(s)", summary.Kinds),
+				ObservedGeneration: generation,
+			})
+			meta.SetStatusCondition(&policy.Status.Conditions, metav1.Condition{
+				Type:               v1alpha1.ConditionErrors,
+				Status:             metav1.ConditionFalse,
+				Reason:             "NoErrors",
+				Message:            "Last sweep had no errors",
+				ObservedGeneration: generation,
+			})
+		}
+	}
+
+	if err := r.Status().Patch(ctx, policy, patch); err != nil {
+		l.Error(err, "Failed to update TtlReaperPolicy status", "policy", policy.Name)
+	}
+}
+
+// mapPolicyToConfiguration enqueues the configuration request of a policy
+func mapPolicyToConfiguration(_ context.Context, policy client.Object) []ctrl.Request {
+	return []ctrl.Request{configurationRequest(policy.GetName())}
+}
+
+// mapToConfigurations enqueues the requests of all loaded configurations, e.g. for tenant policy changes
+func (r *TtlReaperReconciler) mapToConfigurations(_ context.Context, _ client.Object) []ctrl.Request {
+	configs := r.configurations()
+	requests := make([]ctrl.Request, 0, len(configs))
+	for _, config := range configs {
+		requests = append(requests, configurationRequest(config.name))
+	}
+	return requests
+}
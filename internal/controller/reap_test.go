@@ -0,0 +1,238 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/events"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"kubettlreaper/api/v1alpha1"
+	"kubettlreaper/internal/archive"
+	"kubettlreaper/internal/audit"
+	"kubettlreaper/internal/notify"
+)
+
+// failingStore is an archive that is unavailable
+type failingStore struct{}
+
+func (failingStore) Put(context.Context, archive.Snapshot) error {
+	return errors.New("archive unavailable")
+}
+
+func (failingStore) Get(context.Context, types.UID) (archive.Snapshot, error) {
+	return archive.Snapshot{}, archive.ErrNotFound
+}
+
+func (failingStore) Prune(context.Context, time.Time) (int, error) {
+	return 0, nil
+}
+
+var _ = Describe("Reaping with preconditions", func() {
+	var (
+		reconciler *TtlReaperReconciler
+		rule       gvkRule
+		key        = client.ObjectKey{Namespace: "default", Name: "tmp-ttl-pod"}
+	)
+
+	BeforeEach(func() {
+		scheme := runtime.NewScheme()
+		Expect(corev1.AddToScheme(scheme)).To(Succeed())
+		pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+			Namespace:         key.Namespace,
+			Name:              key.Name,
+			UID:               "original",
+			CreationTimestamp: metav1.NewTime(time.Now().Add(-2 * time.Hour)),
+			Labels:            map[string]string{TtlLabel: "1h"},
+		}}
+		c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(pod).Build()
+
+		reconciler = &TtlReaperReconciler{Client: c, Recorder: &events.FakeRecorder{}, apiReader: c}
+		rule = gvkRule{Version: "v1", Kind: "Pod", DryRun: v1alpha1.DryRunNone, action: v1alpha1.ActionDelete}
+		reconciler.setConfiguration("test", &reaperConfig{rules: []gvkRule{rule}})
+	})
+
+	// stale reads the pod's metadata like a sweep listing it does
+	stale := func() *metav1.PartialObjectMetadata {
+		obj := &metav1.PartialObjectMetadata{}
+		obj.SetGroupVersionKind(rule.GroupVersionKind())
+		Expect(reconciler.Get(ctx, key, obj)).To(Succeed())
+		return obj
+	}
+
+	It("should delete an expired object that didn't change", func() {
+		outcome, _, err := reconciler.reap(ctx, stale(), rule)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(outcome).To(Equal(reapReaped))
+
+		err = reconciler.Get(ctx, key, &corev1.Pod{})
+		Expect(apierrors.IsNotFound(err)).To(BeTrue())
+	})
+
+	It("should notify a reap once the sweep's batch is flushed", func() {
+		notifier := &recordingNotifier{}
+		reconciler.Notifier = notifier
+		sweepCtx, batch := withNotificationBatch(ctx)
+
+		outcome, _, err := reconciler.reap(sweepCtx, stale(), rule)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(outcome).To(Equal(reapReaped))
+		Expect(notifier.notifications).To(BeEmpty())
+
+		reconciler.flushNotifications(ctx, batch)
+		Expect(notifier.notifications).To(HaveLen(1))
+		Expect(notifier.notifications[0].Type).To(Equal(notify.TypeReaped))
+		Expect(notifier.notifications[0].Result).To(Equal(notify.ResultSucceeded))
+		Expect(notifier.notifications[0].Object.UID).To(BeEquivalentTo("original"))
+		Expect(notifier.notifications[0].TTL).To(Equal("1h"))
+	})
+
+	It("should notify a dry run skip once until the outcome changes", func() {
+		notifier := &recordingNotifier{}
+		reconciler.Notifier = notifier
+		dryRun := rule
+		dryRun.DryRun = v1alpha1.DryRunClient
+
+		for range 2 {
+			outcome, _, err := reconciler.reap(ctx, stale(), dryRun)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(outcome).To(Equal(reapWouldReap))
+		}
+		Expect(notifier.notifications).To(HaveLen(1))
+		Expect(notifier.notifications[0].Type).To(Equal(notify.TypeSkipped))
+		Expect(notifier.notifications[0].Result).To(Equal(notify.ResultSkipped))
+		Expect(notifier.notifications[0].ExpiresAt).NotTo(BeNil())
+
+		outcome, _, err := reconciler.reap(ctx, stale(), rule)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(outcome).To(Equal(reapReaped))
+		Expect(notifier.notifications).To(HaveLen(2))
+		Expect(notifier.notifications[1].Type).To(Equal(notify.TypeReaped))
+	})
+
+	It("should forget the outcome of an object found already deleted", func() {
+		reconciler.Notifier = &recordingNotifier{}
+		dryRun := rule
+		dryRun.DryRun = v1alpha1.DryRunClient
+		obj := stale()
+		_, _, err := reconciler.reap(ctx, obj, dryRun)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(reconciler.notices.last).To(HaveKey(obj.GetUID()))
+
+		Expect(reconciler.Delete(ctx, &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: key.Namespace, Name: key.Name}})).To(Succeed())
+		outcome, _, err := reconciler.reap(ctx, obj, rule)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(outcome).To(Equal(reapSkipped))
+		Expect(reconciler.notices.last).NotTo(HaveKey(obj.GetUID()))
+	})
+
+	It("should write an audit record of the delete", func() {
+		buf := &bytes.Buffer{}
+		reconciler.AuditLog = audit.New(buf, "v1.2.0", "")
+
+		outcome, _, err := reconciler.reap(ctx, stale(), rule)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(outcome).To(Equal(reapReaped))
+
+		record := audit.Record{}
+		Expect(json.Unmarshal(buf.Bytes(), &record)).To(Succeed())
+		Expect(record.Object.UID).To(BeEquivalentTo("original"))
+		Expect(record.Labels).To(HaveKeyWithValue(TtlLabel, "1h"))
+		Expect(record.Action).To(Equal(string(v1alpha1.ActionDelete)))
+		Expect(record.Outcome).To(Equal(audit.OutcomeSucceeded))
+		Expect(record.AnchorTime).NotTo(BeNil())
+		Expect(record.ExpiresAt).To(BeTemporally("==", record.AnchorTime.Add(time.Hour)))
+		_, err = audit.Verify(buf, "")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("should archive an object before deleting it", func() {
+		store, err := archive.NewDir(GinkgoT().TempDir())
+		Expect(err).NotTo(HaveOccurred())
+		reconciler.Archive = store
+
+		outcome, _, err := reconciler.reap(ctx, stale(), rule)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(outcome).To(Equal(reapReaped))
+
+		snapshot, err := store.Get(ctx, "original")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(snapshot.Kind).To(Equal("Pod"))
+		Expect(snapshot.Object.GetName()).To(Equal(key.Name))
+		Expect(snapshot.Object.GetUID()).To(BeEmpty())
+		Expect(snapshot.Object.GetLabels()).To(HaveKeyWithValue(TtlLabel, "1h"))
+	})
+
+	It("should not delete an object that failed to be archived", func() {
+		reconciler.Archive = failingStore{}
+
+		outcome, _, err := reconciler.reap(ctx, stale(), rule)
+		Expect(err).To(HaveOccurred())
+		Expect(outcome).To(Equal(reapFailed))
+		Expect(reconciler.Get(ctx, key, &corev1.Pod{})).To(Succeed())
+	})
+
+	It("should re-evaluate an object renewed since it was read", func() {
+		obj := stale()
+
+		pod := &corev1.Pod{}
+		Expect(reconciler.Get(ctx, key, pod)).To(Succeed())
+		pod.Labels[TtlLabel] = "24h"
+		Expect(reconciler.Update(ctx, pod)).To(Succeed())
+
+		outcome, remaining, err := reconciler.reap(ctx, obj, rule)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(outcome).To(Equal(reapPending))
+		Expect(remaining).To(BeNumerically("~", 22*time.Hour, time.Minute))
+		Expect(reconciler.Get(ctx, key, &corev1.Pod{})).To(Succeed())
+	})
+
+	It("should raise an InvalidTTL event on an object with an unparsable TTL", func() {
+		recorder := events.NewFakeRecorder(10)
+		reconciler.Recorder = recorder
+		obj := stale()
+		obj.SetLabels(map[string]string{TtlLabel: "soon"})
+
+		outcome, _, err := reconciler.reap(ctx, obj, rule)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(outcome).To(Equal(reapInvalid))
+		Expect(recorder.Events).To(Receive(HavePrefix("Warning InvalidTTL")))
+	})
+
+	It("should treat an object already deleted as reaped by someone else", func() {
+		obj := stale()
+		Expect(reconciler.Delete(ctx, &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: key.Namespace, Name: key.Name}})).
+			To(Succeed())
+
+		outcome, _, err := reconciler.reap(ctx, obj, rule)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(outcome).To(Equal(reapSkipped))
+	})
+})
@@ -0,0 +1,81 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// kindSchedule tracks when each GVK was last swept so kinds are swept on their own interval.
+// Failed kinds are left to kindBackoff until they succeed again
+type kindSchedule struct {
+	mu      sync.Mutex
+	sweptAt map[schema.GroupVersionKind]time.Time
+}
+
+// due reports whether the rule's kind is due a sweep
+func (s *kindSchedule) due(rule gvkRule, now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sweptAt, exists := s.sweptAt[rule.GroupVersionKind()]
+	return !exists || !now.Before(sweptAt.Add(rule.interval))
+}
+
+// swept records a successful sweep of the GVK started at a time
+func (s *kindSchedule) swept(gvk schema.GroupVersionKind, at time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.sweptAt == nil {
+		s.sweptAt = map[schema.GroupVersionKind]time.Time{}
+	}
+	s.sweptAt[gvk] = at
+}
+
+// failed forgets the last sweep of the GVK so it is due as soon as its backoff allows
+func (s *kindSchedule) failed(gvk schema.GroupVersionKind) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.sweptAt, gvk)
+}
+
+// next returns the time until the earliest sweep of a kind swept successfully, false if there is none
+func (s *kindSchedule) next(rules []gvkRule, now time.Time) (time.Duration, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var (
+		next  time.Duration
+		found bool
+	)
+	for _, rule := range rules {
+		sweptAt, exists := s.sweptAt[rule.GroupVersionKind()]
+		if !exists {
+			continue
+		}
+		if remaining := max(sweptAt.Add(rule.interval).Sub(now), 0); !found || remaining < next {
+			next, found = remaining, true
+		}
+	}
+
+	return next, found
+}
@@ -0,0 +1,55 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Sweep schedule", func() {
+	pods := gvkRule{Version: "v1", Kind: "Pod", interval: time.Minute}
+	widgets := gvkRule{Group: "example.com", Version: "v1", Kind: "Widget", interval: time.Hour}
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	It("should sweep each kind on its own interval", func() {
+		s := &kindSchedule{}
+		Expect(s.due(pods, now)).To(BeTrue())
+		s.swept(pods.GroupVersionKind(), now)
+		s.swept(widgets.GroupVersionKind(), now)
+
+		Expect(s.due(pods, now.Add(30*time.Second))).To(BeFalse())
+		Expect(s.due(pods, now.Add(time.Minute))).To(BeTrue())
+		Expect(s.due(widgets, now.Add(time.Minute))).To(BeFalse())
+
+		next, scheduled := s.next([]gvkRule{pods, widgets}, now.Add(15*time.Second))
+		Expect(scheduled).To(BeTrue())
+		Expect(next).To(Equal(45 * time.Second))
+	})
+
+	It("should leave failed kinds to the backoff", func() {
+		s := &kindSchedule{}
+		s.swept(widgets.GroupVersionKind(), now)
+		s.failed(widgets.GroupVersionKind())
+
+		Expect(s.due(widgets, now)).To(BeTrue())
+		_, scheduled := s.next([]gvkRule{widgets}, now)
+		Expect(scheduled).To(BeFalse())
+	})
+})
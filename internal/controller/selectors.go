@@ -0,0 +1,92 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// nameFilter matches object names by prefix or regular expression, all names when empty
+type nameFilter struct {
+	prefixes []string
+	regexes  []*regexp.Regexp
+}
+
+// newNameFilter compiles the name regular expressions, empty prefixes are ignored
+func newNameFilter(prefixes, regexes []string) (nameFilter, error) {
+	filter := nameFilter{}
+	for _, prefix := range prefixes {
+		if prefix != "" {
+			filter.prefixes = append(filter.prefixes, prefix)
+		}
+	}
+	for _, expr := range regexes {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return filter, fmt.Errorf("invalid name regex %q: %w", expr, err)
+		}
+		filter.regexes = append(filter.regexes, re)
+	}
+
+	return filter, nil
+}
+
+// matches reports whether a name has one of the prefixes or matches one of the regexes
+func (f nameFilter) matches(name string) bool {
+	if len(f.prefixes) == 0 && len(f.regexes) == 0 {
+		return true
+	}
+	for _, prefix := range f.prefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	for _, re := range f.regexes {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesFieldSelector asks the API server whether an object matches the field selector of
+// its rule, only the server knows which fields of a kind can be selected
+func (r *TtlReaperReconciler) matchesFieldSelector(ctx context.Context, obj client.Object, rule gvkRule) (bool, error) {
+	if rule.fieldSelector == nil {
+		return true, nil
+	}
+
+	gvk := rule.GroupVersionKind()
+	list := &metav1.PartialObjectMetadataList{}
+	list.SetGroupVersionKind(gvk.GroupVersion().WithKind(gvk.Kind + "List"))
+	selector := fields.AndSelectors(fields.OneTermEqualSelector("metadata.name", obj.GetName()), rule.fieldSelector)
+	if err := r.apiReader.List(ctx, list,
+		client.InNamespace(obj.GetNamespace()),
+		client.MatchingFieldsSelector{Selector: selector},
+	); err != nil {
+		return false, err
+	}
+
+	return len(list.Items) > 0, nil
+}
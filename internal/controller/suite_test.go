@@ -28,6 +28,7 @@ import (
 	. "github.com/onsi/gomega"
 	ctrl "sigs.k8s.io/controller-runtime"
 
+	"kubettlreaper/api/v1alpha1"
 	utils "kubettlreaper/test/utils"
 
 	corev1 "k8s.io/api/core/v1"
@@ -86,6 +87,8 @@ var _ = BeforeSuite(func() {
 
 	err = corev1.AddToScheme(scheme.Scheme)
 	Expect(err).NotTo(HaveOccurred())
+	err = v1alpha1.AddToScheme(scheme.Scheme)
+	Expect(err).NotTo(HaveOccurred())
 
 	// +kubebuilder:scaffold:scheme
 
@@ -99,10 +102,13 @@ var _ = BeforeSuite(func() {
 	})
 	Expect(err).ToNot(HaveOccurred())
 
+	recorder, err := NewEventRecorder(k8sManager)
+	Expect(err).ToNot(HaveOccurred())
+
 	err = (&TtlReaperReconciler{
 		Client:   k8sManager.GetClient(),
 		Scheme:   k8sManager.GetScheme(),
-		Recorder: k8sManager.GetEventRecorderFor("kubettlreaper-controller"),
+		Recorder: recorder,
 	}).SetupWithManager(k8sManager, utils.ConfigurationName)
 	Expect(err).ToNot(HaveOccurred())
 
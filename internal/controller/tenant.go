@@ -0,0 +1,221 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"kubettlreaper/api/v1alpha1"
+	"kubettlreaper/internal/ttl"
+)
+
+// tenantRule is a validated kind of a tenant policy merged with the rule of a configuration,
+// it only applies in the policy namespace to objects claimed by that configuration
+type tenantRule struct {
+	configuration string
+	selector      labels.Selector
+	rule          gvkRule
+}
+
+// tenantRules indexes tenant rules by kind and namespace, in policy name order
+type tenantRules map[schema.GroupVersionKind]map[string][]tenantRule
+
+// add indexes the rules of a tenant policy
+func (t tenantRules) add(namespace string, rules []tenantRule) {
+	for _, rule := range rules {
+		gvk := rule.rule.GroupVersionKind()
+		if t[gvk] == nil {
+			t[gvk] = map[string][]tenantRule{}
+		}
+		t[gvk][namespace] = append(t[gvk][namespace], rule)
+	}
+}
+
+// match returns the first tenant rule of the object's namespace and configuration that selects it
+func (t tenantRules) match(gvk schema.GroupVersionKind, obj client.Object, configuration string) (gvkRule, bool) {
+	for _, rule := range t[gvk][obj.GetNamespace()] {
+		if rule.configuration == configuration && rule.selector.Matches(labels.Set(obj.GetLabels())) {
+			return rule.rule, true
+		}
+	}
+	return gvkRule{}, false
+}
+
+// newTenantRules validates a tenant policy against the cluster configurations and merges
+// each kind with the rule it narrows of every configuration enabling the kind, so it applies
+// whichever of them claims an object. Anything that would widen the scope of a cluster policy
+// is refused: kinds none enables, cluster-scoped kinds, a longer max-lifetime and another
+// ttl-start, which could start the countdown sooner. Dry run and the name prefix always come
+// from the cluster policy
+func newTenantRules(policy *v1alpha1.TtlReaperTenantPolicy, configs []namedConfig, restMapper meta.RESTMapper) ([]tenantRule, error) {
+	var rules []tenantRule
+	for i, kind := range policy.Spec.Kinds {
+		gvk := schema.GroupVersionKind{Group: kind.Group, Version: kind.Version, Kind: kind.Kind}
+		enabled := slices.ContainsFunc(configs, func(config namedConfig) bool {
+			_, ok := config.config.rule(gvk)
+			return ok
+		})
+		if !enabled {
+			return nil, fmt.Errorf("kind %d (%s): not enabled by the cluster policy", i, gvk)
+		}
+		mapping, err := restMapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+		if err != nil {
+			return nil, fmt.Errorf("kind %d (%s): %w", i, gvk, err)
+		}
+		if mapping.Scope.Name() != meta.RESTScopeNameNamespace {
+			return nil, fmt.Errorf("kind %d (%s): cluster-scoped kinds can't be reaped by a tenant policy", i, gvk)
+		}
+
+		selector := labels.Everything()
+		if kind.Selector != nil {
+			if selector, err = metav1.LabelSelectorAsSelector(kind.Selector); err != nil {
+				return nil, fmt.Errorf("kind %d (%s): invalid selector: %w", i, gvk, err)
+			}
+		}
+
+		for _, config := range configs {
+			clusterRule, ok := config.config.rule(gvk)
+			if !ok {
+				continue
+			}
+			rule, err := mergeTenantKind(kind, clusterRule)
+			if err != nil {
+				return nil, fmt.Errorf("kind %d (%s): configuration %s: %w", i, gvk, config.name, err)
+			}
+			rules = append(rules, tenantRule{configuration: config.name, selector: selector, rule: rule})
+		}
+	}
+
+	return rules, nil
+}
+
+// mergeTenantKind narrows the rule of a cluster configuration with a kind of a tenant policy
+func mergeTenantKind(kind v1alpha1.TenantKindRule, clusterRule gvkRule) (gvkRule, error) {
+	rule := clusterRule
+	if kind.TtlStart != "" {
+		if err := validateTtlStart(kind.TtlStart); err != nil {
+			return gvkRule{}, err
+		}
+		if kind.TtlStart != cmp.Or(clusterRule.TtlStart, TtlStartCreation) {
+			return gvkRule{}, fmt.Errorf("ttl-start %s differs from the cluster policy's %s",
+				kind.TtlStart, cmp.Or(clusterRule.TtlStart, TtlStartCreation))
+		}
+	}
+	if kind.MaxLifetime != "" {
+		maxLifetime, err := ttl.ParseDuration(kind.MaxLifetime)
+		if err != nil {
+			return gvkRule{}, fmt.Errorf("invalid max-lifetime: %w", err)
+		}
+		if clusterRule.maxLifetime > 0 && (maxLifetime == 0 || maxLifetime > clusterRule.maxLifetime) {
+			return gvkRule{}, fmt.Errorf("max-lifetime %s exceeds the cluster policy's %s",
+				kind.MaxLifetime, clusterRule.maxLifetime)
+		}
+		rule.maxLifetime = maxLifetime
+	}
+	if kind.DefaultTtl != "" {
+		defaultTtl, err := ttl.ParseDuration(kind.DefaultTtl)
+		if err != nil || defaultTtl <= 0 {
+			return gvkRule{}, fmt.Errorf("invalid default TTL %q", kind.DefaultTtl)
+		}
+		// Objects without the TTL label are only found when the cluster policy lists them
+		if !clusterRule.listUnlabeled {
+			return gvkRule{}, fmt.Errorf("a default TTL needs the cluster policy to list unlabeled objects")
+		}
+		rule.defaultTtl = defaultTtl
+	}
+	return rule, nil
+}
+
+// loadTenantPolicies validates all tenant policies against the loaded configurations,
+// reporting rejected policies in their status
+func (r *TtlReaperReconciler) loadTenantPolicies(ctx context.Context) tenantRules {
+	l := log.FromContext(ctx)
+	if !r.tenantsEnabled {
+		return nil
+	}
+
+	policies := &v1alpha1.TtlReaperTenantPolicyList{}
+	if err := r.List(ctx, policies); err != nil {
+		l.Error(err, "Failed to list TtlReaperTenantPolicies, ignoring tenant policies")
+		return nil
+	}
+	slices.SortFunc(policies.Items, func(a, b v1alpha1.TtlReaperTenantPolicy) int {
+		return cmp.Or(strings.Compare(a.Namespace, b.Namespace), strings.Compare(a.Name, b.Name))
+	})
+
+	configs := r.configurations()
+	tenants := tenantRules{}
+	for i := range policies.Items {
+		policy := &policies.Items[i]
+		rules, err := newTenantRules(policy, configs, r.restMapper)
+		if err != nil {
+			l.Info("Rejected TtlReaperTenantPolicy", "policy", client.ObjectKeyFromObject(policy), "reason", err.Error())
+		} else {
+			tenants.add(policy.Namespace, rules)
+		}
+		r.updateTenantPolicyStatus(ctx, policy, err)
+	}
+
+	return tenants
+}
+
+// updateTenantPolicyStatus sets the Valid condition of a tenant policy, only patching
+// and raising an event when it changes as tenant policies are checked on every sweep
+func (r *TtlReaperReconciler) updateTenantPolicyStatus(ctx context.Context, policy *v1alpha1.TtlReaperTenantPolicy, rejectErr error) {
+	l := log.FromContext(ctx)
+	original := policy.DeepCopy()
+	generation := policy.GetGeneration()
+	policy.Status.ObservedGeneration = generation
+
+	condition := metav1.Condition{
+		Type:               v1alpha1.ConditionValid,
+		Status:             metav1.ConditionTrue,
+		Reason:             "Accepted",
+		Message:            "Policy is merged with the cluster policy",
+		ObservedGeneration: generation,
+	}
+	if rejectErr != nil {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "Rejected"
+		condition.Message = rejectErr.Error()
+	}
+	meta.SetStatusCondition(&policy.Status.Conditions, condition)
+
+	if equality.Semantic.DeepEqual(original.Status, policy.Status) {
+		return
+	}
+	if err := r.Status().Patch(ctx, policy, client.MergeFrom(original)); err != nil {
+		l.Error(err, "Failed to update TtlReaperTenantPolicy status", "policy", client.ObjectKeyFromObject(policy))
+		return
+	}
+	if rejectErr != nil {
+		policy.SetGroupVersionKind(v1alpha1.GroupVersion.WithKind("TtlReaperTenantPolicy"))
+		r.raiseEvent(policy, "Warning", "InvalidConfig", eventActionConfigure, rejectErr.Error())
+	}
+}
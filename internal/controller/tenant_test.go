@@ -0,0 +1,164 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"slices"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"kubettlreaper/api/v1alpha1"
+)
+
+var _ = Describe("Tenant policies", func() {
+	podGVK := schema.GroupVersionKind{Version: "v1", Kind: "Pod"}
+	namespaceGVK := schema.GroupVersionKind{Version: "v1", Kind: "Namespace"}
+	secretGVK := schema.GroupVersionKind{Version: "v1", Kind: "Secret"}
+
+	restMapper := meta.NewDefaultRESTMapper(nil)
+	restMapper.Add(podGVK, meta.RESTScopeNamespace)
+	restMapper.Add(secretGVK, meta.RESTScopeNamespace)
+	restMapper.Add(namespaceGVK, meta.RESTScopeRoot)
+
+	config, err := newReaperConfig(v1alpha1.TtlReaperPolicySpec{
+		CheckInterval: "5m",
+		MaxLifetime:   "7d",
+		DryRun:        v1alpha1.DryRunClient,
+		Kinds: []v1alpha1.KindRule{
+			{Version: "v1", Kind: "Pod", ListUnlabeled: true},
+			{Version: "v1", Kind: "Namespace"},
+		},
+	})
+	if err != nil {
+		panic(err)
+	}
+	configs := []namedConfig{{name: "cluster", config: config}}
+
+	newPolicy := func(kinds ...v1alpha1.TenantKindRule) *v1alpha1.TtlReaperTenantPolicy {
+		return &v1alpha1.TtlReaperTenantPolicy{
+			ObjectMeta: metav1.ObjectMeta{Name: "ci", Namespace: "team-a"},
+			Spec:       v1alpha1.TtlReaperTenantPolicySpec{Kinds: kinds},
+		}
+	}
+
+	It("should merge a tenant kind with the cluster rule", func() {
+		rules, err := newTenantRules(newPolicy(v1alpha1.TenantKindRule{
+			Version:     "v1",
+			Kind:        "Pod",
+			DefaultTtl:  "2h",
+			MaxLifetime: "1d",
+			Selector:    &metav1.LabelSelector{MatchLabels: map[string]string{"app": "ci"}},
+		}), configs, restMapper)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(rules).To(HaveLen(1))
+		Expect(rules[0].rule.defaultTtl).To(Equal(2 * time.Hour))
+		Expect(rules[0].rule.maxLifetime).To(Equal(24 * time.Hour))
+		// Dry run can't be turned off by a tenant
+		Expect(rules[0].rule.DryRun).To(Equal(v1alpha1.DryRunClient))
+	})
+
+	It("should refuse anything that widens the cluster policy", func() {
+		By("refusing a kind the cluster policy doesn't enable")
+		_, err := newTenantRules(newPolicy(v1alpha1.TenantKindRule{Version: "v1", Kind: "Secret"}), configs, restMapper)
+		Expect(err).To(MatchError(ContainSubstring("not enabled by the cluster policy")))
+
+		By("refusing a default TTL for a kind only listed with the TTL label")
+		labeled, err := newReaperConfig(v1alpha1.TtlReaperPolicySpec{
+			CheckInterval: "5m",
+			Kinds:         []v1alpha1.KindRule{{Version: "v1", Kind: "Pod"}},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		_, err = newTenantRules(newPolicy(v1alpha1.TenantKindRule{Version: "v1", Kind: "Pod", DefaultTtl: "2h"}),
+			[]namedConfig{{name: "cluster", config: labeled}}, restMapper)
+		Expect(err).To(MatchError(ContainSubstring("list unlabeled objects")))
+
+		By("refusing a cluster-scoped kind")
+		_, err = newTenantRules(newPolicy(v1alpha1.TenantKindRule{Version: "v1", Kind: "Namespace"}), configs, restMapper)
+		Expect(err).To(MatchError(ContainSubstring("cluster-scoped")))
+
+		By("refusing a longer max-lifetime")
+		_, err = newTenantRules(newPolicy(v1alpha1.TenantKindRule{Version: "v1", Kind: "Pod", MaxLifetime: "30d"}),
+			configs, restMapper)
+		Expect(err).To(MatchError(ContainSubstring("exceeds the cluster policy")))
+
+		By("refusing another ttl-start")
+		_, err = newTenantRules(newPolicy(v1alpha1.TenantKindRule{Version: "v1", Kind: "Pod", TtlStart: TtlStartLastUpdate}),
+			configs, restMapper)
+		Expect(err).To(MatchError(ContainSubstring("differs from the cluster policy")))
+		_, err = newTenantRules(newPolicy(v1alpha1.TenantKindRule{Version: "v1", Kind: "Pod", TtlStart: TtlStartCreation}),
+			configs, restMapper)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("should merge a tenant kind with every configuration enabling it", func() {
+		other, err := newReaperConfig(v1alpha1.TtlReaperPolicySpec{
+			CheckInterval: "5m",
+			MaxLifetime:   "3d",
+			Kinds:         []v1alpha1.KindRule{{Version: "v1", Kind: "Pod", ListUnlabeled: true}},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		both := append(slices.Clone(configs), namedConfig{name: "other", config: other})
+
+		rules, err := newTenantRules(newPolicy(v1alpha1.TenantKindRule{Version: "v1", Kind: "Pod", DefaultTtl: "2h"}),
+			both, restMapper)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(rules).To(HaveLen(2))
+		tenants := tenantRules{}
+		tenants.add("team-a", rules)
+		pod := &metav1.PartialObjectMetadata{ObjectMeta: metav1.ObjectMeta{Name: "runner", Namespace: "team-a"}}
+		rule, ok := tenants.match(podGVK, pod, "other")
+		Expect(ok).To(BeTrue())
+		Expect(rule.defaultTtl).To(Equal(2 * time.Hour))
+		Expect(rule.maxLifetime).To(Equal(72 * time.Hour))
+
+		By("refusing a kind that widens any of them, naming it")
+		_, err = newTenantRules(newPolicy(v1alpha1.TenantKindRule{Version: "v1", Kind: "Pod", MaxLifetime: "5d"}),
+			both, restMapper)
+		Expect(err).To(MatchError(ContainSubstring("configuration other: max-lifetime 5d exceeds")))
+	})
+
+	It("should only match objects in the tenant namespace selected by the tenant rule", func() {
+		rules, err := newTenantRules(newPolicy(v1alpha1.TenantKindRule{
+			Version:    "v1",
+			Kind:       "Pod",
+			DefaultTtl: "2h",
+			Selector:   &metav1.LabelSelector{MatchLabels: map[string]string{"app": "ci"}},
+		}), configs, restMapper)
+		Expect(err).NotTo(HaveOccurred())
+		tenants := tenantRules{}
+		tenants.add("team-a", rules)
+
+		pod := func(namespace, app string) *metav1.PartialObjectMetadata {
+			return &metav1.PartialObjectMetadata{ObjectMeta: metav1.ObjectMeta{
+				Name: "runner", Namespace: namespace, Labels: map[string]string{"app": app},
+			}}
+		}
+		_, ok := tenants.match(podGVK, pod("team-a", "ci"), "cluster")
+		Expect(ok).To(BeTrue())
+		_, ok = tenants.match(podGVK, pod("team-a", "web"), "cluster")
+		Expect(ok).To(BeFalse())
+		_, ok = tenants.match(podGVK, pod("team-b", "ci"), "cluster")
+		Expect(ok).To(BeFalse())
+		_, ok = tenants.match(podGVK, pod("team-a", "ci"), "other")
+		Expect(ok).To(BeFalse())
+	})
+})
@@ -17,213 +17,754 @@ limitations under the License.
 package controller
 
 import (
+	"cmp"
 	"context"
+	"errors"
 	"fmt"
 	"os"
+	"slices"
 	"strings"
+	"sync"
 	"time"
 
-	"gopkg.in/yaml.v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/sync/errgroup"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
-	"k8s.io/client-go/tools/record"
+	"k8s.io/apimachinery/pkg/selection"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/tools/events"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
+
+	"kubettlreaper/api/v1alpha1"
+	"kubettlreaper/internal/archive"
+	"kubettlreaper/internal/audit"
+	"kubettlreaper/internal/notify"
 )
 
 const (
-	TtlLabel = "kubettlreaper.samir.io/ttl"
+	TtlLabel            = "kubettlreaper.samir.io/ttl"
+	ExpiresAtAnnotation = "kubettlreaper.samir.io/expires-at"
+	RenewedAtAnnotation = "kubettlreaper.samir.io/renewed-at"
+
+	defaultPageSize     = 500
+	defaultSweepWorkers = 4
+	defaultSweepTimeout = 5 * time.Minute
+
+	// maxConcurrentConfigurations is the number of configurations swept at once
+	maxConcurrentConfigurations = 4
+
+	// maxReapAttempts bounds the re-evaluations of an object that keeps changing under a delete
+	maxReapAttempts = 3
 )
 
 var (
 	OperatorNamespace = os.Getenv("OPERATOR_NAMESPACE")
+
+	// ttlLabelRequirement selects the objects with the TTL label
+	ttlLabelRequirement, _ = labels.NewRequirement(TtlLabel, selection.Exists, nil)
+)
+
+// reapOutcome is what reap did with an object
+type reapOutcome int
+
+const (
+	reapSkipped reapOutcome = iota
+	reapPending
+	reapInvalid
+	reapWouldReap
+	reapReaped
+	reapFailed
+	reapConditionNotMet
+	reapActioned
+	reapDeletionPending
 )
 
+// sweepCounts tallies the reap outcomes of a sweep
+type sweepCounts struct {
+	matched, reaped, wouldReap, failed, invalid, skipped, actioned, pendingDeletion int32
+}
+
+// add counts the outcome of an object with an expiry
+func (c *sweepCounts) add(outcome reapOutcome) {
+	c.matched++
+	switch outcome {
+	case reapReaped:
+		c.reaped++
+	case reapWouldReap:
+		c.wouldReap++
+	case reapFailed:
+		c.failed++
+	case reapInvalid:
+		c.invalid++
+	case reapConditionNotMet:
+		c.skipped++
+	case reapActioned:
+		c.actioned++
+	case reapDeletionPending:
+		c.pendingDeletion++
+	}
+}
+
+// addTo adds the counts to a policy sweep summary
+func (c sweepCounts) addTo(summary *v1alpha1.SweepSummary) {
+	summary.Matched += c.matched
+	summary.Reaped += c.reaped
+	summary.WouldReap += c.wouldReap
+	summary.Failed += c.failed
+	summary.Invalid += c.invalid
+	summary.Skipped += c.skipped
+	summary.Actioned += c.actioned
+	summary.PendingDeletion += c.pendingDeletion
+}
+
 // TtlReaperReconciler reconciles a TtlReaper object
 type TtlReaperReconciler struct {
 	client.Client
-	Scheme            *runtime.Scheme
-	ConfigurationName string
-	Recorder          record.EventRecorder
+	Scheme   *runtime.Scheme
+	Recorder events.EventRecorder
+	// ConfigurationNames of the configurations to run, in addition to those carrying ConfigurationLabel
+	ConfigurationNames []string
+	// MigrateConfiguration creates a TtlReaperPolicy from the configMap when there is none
+	MigrateConfiguration bool
+	// Notifier dispatches notifications about expiring and reaped objects, optional
+	Notifier notify.Notifier
+	// AuditLog records the actions taken on expired objects, optional
+	AuditLog *audit.Log
+	// Archive stores the manifests of expired objects before they are deleted, optional
+	Archive archive.Store
+
+	// State shared with the expiry controller, set from the configurations on every sweep
+	mu               sync.RWMutex
+	configs          map[string]*configState
+	configOrder      []string
+	tenants          tenantRules
+	notices          outcomeNotices
+	notifications    chan []queuedNotification
+	deliveries       deliveries
+	watched          map[schema.GroupVersionKind]cache.Cache
+	expiryController controller.TypedController[expiryRequest]
+	cache            cache.Cache
+	labeledCache     cache.Cache
+	restMapper       meta.RESTMapper
+	discovery        discovery.DiscoveryInterface
+	apiReader        client.Reader
+	// policyEnabled and tenantsEnabled are set when the policy CRDs are installed
+	policyEnabled  bool
+	tenantsEnabled bool
 }
 
 // +kubebuilder:rbac:groups=core,resources=*,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=core,resources=*/status,verbs=get;update;patch
 // +kubebuilder:rbac:groups=core,resources=*/finalizers,verbs=update
-
-// Reconcile runs the main loop to house-keep objects with a TTL
-func (r *TtlReaperReconciler) Reconcile(ctx context.Context, _ ctrl.Request) (ctrl.Result, error) {
+// +kubebuilder:rbac:groups=events.k8s.io,resources=events,verbs=create;patch
+// +kubebuilder:rbac:groups=apiextensions.k8s.io,resources=customresourcedefinitions,verbs=get
+// +kubebuilder:rbac:groups=apps,resources=deployments/scale;statefulsets/scale;replicasets/scale,verbs=get;update;patch
+// +kubebuilder:rbac:groups=batch,resources=jobs;cronjobs,verbs=get;patch
+// +kubebuilder:rbac:groups=kubettlreaper.samir.io,resources=ttlreaperpolicies,verbs=get;list;watch;create
+// +kubebuilder:rbac:groups=kubettlreaper.samir.io,resources=ttlreaperpolicies/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=kubettlreaper.samir.io,resources=ttlreapertenantpolicies,verbs=get;list;watch
+// +kubebuilder:rbac:groups=kubettlreaper.samir.io,resources=ttlreapertenantpolicies/status,verbs=get;update;patch
+
+// Reconcile runs the main loop to house-keep objects with a TTL for a configuration,
+// each configuration is reconciled independently on its own interval
+func (r *TtlReaperReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	l := log.FromContext(ctx)
+	name := req.Name
 
-	l.Info("Reconciling", "ConfigurationName", r.ConfigurationName)
+	l.Info("Reconciling", "ConfigurationName", name)
 
-	// Fetch the ConfigMap that stores the GVKs
-	configMap := &corev1.ConfigMap{}
-	err := r.Get(ctx, client.ObjectKey{
-		Namespace: OperatorNamespace,
-		Name:      r.ConfigurationName,
-	}, configMap)
+	// Fetch the TtlReaperPolicy, or the ConfigMap when there is none
+	policy, configMap, err := r.fetchConfiguration(ctx, name)
+	if apierrors.IsNotFound(err) {
+		// Deleted or no longer marked, it is reconciled again if it comes back
+		if r.forgetConfiguration(name) {
+			l.Info("Configuration removed, no longer reaping for it", "ConfigurationName", name)
+		}
+		return ctrl.Result{}, nil
+	}
 	if err != nil {
-		l.Error(err, "Failed to fetch ConfigMap")
+		l.Error(err, "Failed to fetch configuration")
 		return ctrl.Result{RequeueAfter: 10 * time.Second}, err
 	}
 
-	// Fetch the requeue interval from the ConfigMap
-	requeueAfterTime, err := r.getRequeueTimeFromConfigMap(configMap)
-	if err != nil {
-		l.Error(err, "Failed to get requeue interval from ConfigMap")
-		return ctrl.Result{}, err
+	var (
+		source client.Object = configMap
+		spec   v1alpha1.TtlReaperPolicySpec
+	)
+	if policy != nil {
+		source, spec = policy, policy.Spec
+	} else {
+		spec, err = policySpecFromConfigMap(configMap)
 	}
-	l.Info("Requeue interval fetched from ConfigMap", "requeueAfter", requeueAfterTime)
 
-	// Parse the GVKs from the ConfigMap data
-	var gvkList []schema.GroupVersionKind
-	err = yaml.Unmarshal([]byte(configMap.Data["gvk-list"]), &gvkList)
+	// Validate the configuration and apply the defaults
+	var config *reaperConfig
+	if err == nil {
+		config, err = newReaperConfig(spec)
+	}
+	if err == nil {
+		err = r.validateActions(ctx, config)
+	}
 	if err != nil {
-		l.Error(err, "Failed to parse GVK list")
-		return ctrl.Result{RequeueAfter: requeueAfterTime}, err
+		l.Error(err, "Invalid configuration")
+		r.raiseEvent(source, "Warning", "InvalidConfig", eventActionConfigure, err.Error())
+		if policy != nil {
+			// Wait for the policy to be fixed, it is watched
+			r.updatePolicyStatus(ctx, policy, err, nil, nil)
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
 	}
+	l.Info("Requeue interval fetched from configuration", "requeueAfter", config.checkInterval)
 
-	r.raiseEvent(configMap, "Normal", "ValidConfig", "Processing GVKs from configMap")
+	for _, rule := range config.rules {
+		if rule.conditionErr != nil {
+			l.Error(rule.conditionErr, "Invalid condition, expired objects of the kind won't be reaped")
+			r.raiseEvent(source, "Warning", "InvalidCondition", eventActionConfigure, rule.conditionErr.Error())
+		}
+	}
+	if policy == nil {
+		r.raiseEvent(source, "Normal", "ValidConfig", eventActionConfigure, "Processing GVKs from configMap")
+		if r.MigrateConfiguration {
+			r.migrateConfiguration(ctx, configMap, spec)
+		}
+	} else {
+		r.raiseEvent(source, "Normal", "ValidConfig", eventActionConfigure, "Processing GVKs from TtlReaperPolicy")
+	}
 
 	// Log and skip processing if GVK list is empty
-	if len(gvkList) == 0 {
+	if len(config.rules) == 0 {
 		l.Info("GVK list is empty, skipping reconciliation")
-		return ctrl.Result{RequeueAfter: requeueAfterTime}, nil
+		if policy != nil {
+			r.updatePolicyStatus(ctx, policy, nil, nil, nil)
+		}
+		return ctrl.Result{RequeueAfter: config.checkInterval}, nil
 	} else {
-		l.Info("GVK list is not empty", "gvkList", gvkList)
+		l.Info("GVK list is not empty", "gvkList", config.rules)
 	}
 
-	// Fetch name prefix from ConfigMap is defined
-	namePrefix := r.getNamePrefix(configMap)
-	if namePrefix != "" {
-		l.Info("Name prefix fetched from ConfigMap", "namePrefix", namePrefix)
+	if spec.NamePrefix != "" {
+		l.Info("Name prefix fetched from configuration", "namePrefix", spec.NamePrefix)
 	}
 
-	// Loop through each GVK and list the resources
-	for _, gvk := range gvkList {
-		resources := &unstructured.UnstructuredList{}
-		resources.SetGroupVersionKind(gvk)
+	// Share the config merged with the tenant policies with the expiry controller and watch any new kinds
+	state := r.setConfiguration(name, config)
+	r.setTenants(r.loadTenantPolicies(ctx))
+	r.watchKinds(ctx, config.rules)
+
+	// Sweep each GVK due on a bounded pool of workers, this full sweep is a safety net for
+	// missed or not yet watched objects as the expiry controller reaps on time.
+	// Workers don't cancel each other so a slow or failing GVK can't hold up the rest,
+	// failing GVKs are retried with exponential backoff
+	var (
+		resultsMu sync.Mutex
+		errs      []error
+		summary   = &v1alpha1.SweepSummary{StartTime: metav1.Now()}
+	)
+	state.backoff.retain(config.rules)
+	sweepCtx, notifications := withNotificationBatch(ctx)
+	sweeps := new(errgroup.Group)
+	sweeps.SetLimit(config.sweepWorkers)
+	for _, rule := range config.rules {
+		gvk := rule.GroupVersionKind()
+		if !state.schedule.due(rule, summary.StartTime.Time) {
+			continue
+		}
+		if ready, retryAt := state.backoff.ready(gvk, time.Now()); !ready {
+			l.Info("Skipping GVK in backoff after failures", "gvk", gvk.String(), "retryAt", retryAt)
+			resultsMu.Lock()
+			summary.FailedKinds = append(summary.FailedKinds, gvkLabel(gvk))
+			resultsMu.Unlock()
+			continue
+		}
 
-		opts := []client.ListOption{
-			client.HasLabels{TtlLabel},
+		timeout := config.sweepTimeout
+		if rule.timeout > 0 {
+			timeout = rule.timeout
 		}
+		sweeps.Go(func() error {
+			kindCtx, cancel := context.WithTimeout(sweepCtx, timeout)
+			defer cancel()
+			counts, err := r.sweepKind(kindCtx, name, rule, config.pageSize)
+
+			resultsMu.Lock()
+			defer resultsMu.Unlock()
+			counts.addTo(summary)
+			if err != nil {
+				state.schedule.failed(gvk)
+				retryAt := state.backoff.failed(gvk, time.Now())
+				l.Info("Backing off GVK after failure", "gvk", gvk.String(), "retryAt", retryAt)
+				errs = append(errs, fmt.Errorf("%s: %w", gvk.String(), err))
+				summary.FailedKinds = append(summary.FailedKinds, gvkLabel(gvk))
+				return nil
+			}
+			state.backoff.succeeded(gvk)
+			state.schedule.swept(gvk, summary.StartTime.Time)
+			summary.Kinds++
+			return nil
+		})
+	}
+	_ = sweeps.Wait()
+	r.flushNotifications(ctx, notifications)
+	summary.CompletionTime = metav1.Now()
+	slices.Sort(summary.FailedKinds)
+
+	sweepErr := utilerrors.NewAggregate(errs)
+	if sweepErr != nil {
+		l.Error(sweepErr, "Failed to sweep some GVKs")
+		r.raiseEvent(source, "Warning", "SweepFailed", eventActionSweep, fmt.Sprintf("Failed to sweep %d GVK(s): %v", len(errs), sweepErr))
+	}
+	if policy != nil {
+		r.updatePolicyStatus(ctx, policy, nil, summary, sweepErr)
+	}
+
+	// Come back when the next kind is due, or early to retry a backed off GVK. Returning
+	// the error instead would requeue on the default rate limiter and drop the intervals
+	requeueAfter := config.checkInterval
+	if nextIn, scheduled := state.schedule.next(config.rules, time.Now()); scheduled {
+		requeueAfter = nextIn
+	}
+	if retryIn, pending := state.backoff.nextRetry(time.Now()); pending && retryIn < requeueAfter {
+		requeueAfter = retryIn
+	}
+
+	return ctrl.Result{RequeueAfter: max(requeueAfter, time.Second)}, nil
+}
 
-		if err := r.Client.List(context.Background(), resources, opts...); err != nil {
+// sweepKind lists a GVK and reaps the expired resources claimed by the configuration
+func (r *TtlReaperReconciler) sweepKind(ctx context.Context, configuration string, rule gvkRule, pageSize int64) (sweepCounts, error) {
+	l := log.FromContext(ctx)
+	gvk := rule.GroupVersionKind()
+	start := time.Now()
+	defer func() {
+		sweepDuration.WithLabelValues(configuration, gvkLabel(gvk)).Observe(time.Since(start).Seconds())
+	}()
+
+	// List metadata only, a page at a time, straight from the API server, with the
+	// rule's label and field selectors and only objects with the TTL label unless the
+	// rule lists unlabeled objects. Annotations can't be selected server side, so then
+	// keep resources carrying either the TTL label or the expires-at annotation
+	counts := sweepCounts{}
+	pending := pendingCounts{}
+	continueToken := ""
+	for {
+		resources := &metav1.PartialObjectMetadataList{}
+		resources.SetGroupVersionKind(gvk.GroupVersion().WithKind(gvk.Kind + "List"))
+
+		opts := []client.ListOption{
+			client.Limit(pageSize),
+			client.Continue(continueToken),
+			client.MatchingLabelsSelector{Selector: rule.listSelector()},
+		}
+		if rule.fieldSelector != nil {
+			opts = append(opts, client.MatchingFieldsSelector{Selector: rule.fieldSelector})
+		}
+		if err := r.apiReader.List(ctx, resources, opts...); err != nil {
 			l.Error(err, "Failed to list resources", "gvk", gvk.String())
-			return ctrl.Result{}, err
+			return counts, err
 		}
 
-		// Apply name prefix filtering (if set)
-		if namePrefix != "" {
-			filteredResources := &unstructured.UnstructuredList{}
-			filteredResources.SetGroupVersionKind(gvk)
+		// Loop through each resource and check TTL, errors are logged by reap
+		for i := range resources.Items {
+			resource := &resources.Items[i]
+			// Items come back typed as PartialObjectMetadata
+			resource.SetGroupVersionKind(gvk)
 
-			for _, item := range resources.Items {
-				if strings.HasPrefix(item.GetName(), namePrefix) {
-					filteredResources.Items = append(filteredResources.Items, item)
-				}
+			// Skip objects claimed by another configuration, this also applies the name prefix,
+			// namespace filters and tenant rules of the object's namespace, then apply expiry filtering
+			objRule, owner, ok := r.getRule(ctx, gvk, resource)
+			if !ok || owner != configuration || !hasExpiryFor(resource, objRule) {
+				continue
 			}
 
-			resources = filteredResources
+			outcome, remaining, _ := r.reap(ctx, resource, objRule)
+			counts.add(outcome)
+			switch outcome {
+			case reapPending:
+				pending.add(remaining)
+			case reapDeletionPending:
+				// Only reported by the sweep, the expiry controller sees every finalizer update
+				r.reportPendingDeletion(ctx, resource)
+			}
 		}
 
-		// Log and skip if no resources found for the GVK
-		if len(resources.Items) == 0 {
-			l.Info("No resources found for GVK, skipping", "gvk", gvk.String())
-			continue
-		} else {
-			l.Info("Resources found", "count", len(resources.Items), "gvk", gvk.String())
+		continueToken = resources.GetContinue()
+		if continueToken == "" {
+			break
 		}
+	}
 
-		// Loop through each resource and check TTL
-		for _, resource := range resources.Items {
-			ttlValue, exists := resource.GetLabels()[TtlLabel]
-			if !exists {
-				continue
-			}
+	// Log if no resources found for the GVK
+	if counts.matched == 0 {
+		l.Info("No resources found for GVK, skipping", "gvk", gvk.String())
+	} else {
+		l.Info("Resources found", "count", counts.matched, "gvk", gvk.String())
+	}
 
-			ttlDuration, err := time.ParseDuration(ttlValue)
-			if err != nil {
-				l.Error(err, "Invalid TTL value", "resource", resource.GetName())
-				continue
-			}
+	pending.record(configuration, gvk)
+	pendingDeletion.WithLabelValues(configuration, gvkLabel(gvk)).Set(float64(counts.pendingDeletion))
+	lastSuccessfulSweep.WithLabelValues(configuration, gvkLabel(gvk)).SetToCurrentTime()
 
-			creationTime := resource.GetCreationTimestamp().Time
-			expirationTime := creationTime.Add(ttlDuration)
+	return counts, nil
+}
 
-			if time.Now().After(expirationTime) {
-				l.Info("Deleting expired resource", "resource", resource.GetName(), "gvk", gvk.String())
-				if err := r.Client.Delete(ctx, &resource); err != nil {
-					l.Error(err, "Failed to delete resource", "resource", resource.GetName())
-				}
-				r.raiseEvent(&resource, "Normal", "ReapedOnTTL", "Deleted due to expired TTL")
-			}
+// reap deletes the object if its TTL has expired, otherwise it returns the time left.
+// Deletes are conditional on the UID and resourceVersion the TTL was evaluated on, when
+// the object changed in the meantime it is read again and re-evaluated
+func (r *TtlReaperReconciler) reap(ctx context.Context, obj client.Object, rule gvkRule) (reapOutcome, time.Duration, error) {
+	for attempt := 1; ; attempt++ {
+		outcome, remaining, err := r.reapOnce(ctx, obj, rule)
+		if !apierrors.IsConflict(err) {
+			return outcome, remaining, err
+		}
+		if attempt == maxReapAttempts {
+			log.FromContext(ctx).Error(err, "Resource keeps changing, giving up deleting it", "resource", obj.GetName())
+			r.raiseEvent(obj, "Warning", "ReapFailed", eventActionReap, fmt.Sprintf("Failed to delete expired object: %v", err))
+			expiresAt, _ := getExpirationTime(obj, rule)
+			r.notifyOutcome(ctx, obj, rule, v1alpha1.ActionDelete, expiresAt, err, "Failed to delete expired object")
+			r.audit(ctx, obj, rule, v1alpha1.ActionDelete, expiresAt, audit.OutcomeFailed, err)
+			reapFailedTotal.With(prometheus.Labels{"gvk": gvkLabel(rule.GroupVersionKind()), "namespace": obj.GetNamespace()}).Inc()
+			return reapFailed, 0, err
 		}
+
+		var ok bool
+		if obj, rule, ok, err = r.reread(ctx, obj, rule); !ok || err != nil {
+			return reapSkipped, 0, err
+		}
+	}
+}
+
+// reread reads an object that changed since its TTL was evaluated straight from the API
+// server, with the rule that now applies to it. False if it is gone or no longer reaped
+func (r *TtlReaperReconciler) reread(ctx context.Context, obj client.Object, rule gvkRule) (client.Object, gvkRule, bool, error) {
+	gvk := rule.GroupVersionKind()
+	fresh := &metav1.PartialObjectMetadata{}
+	fresh.SetGroupVersionKind(gvk)
+	if err := r.apiReader.Get(ctx, client.ObjectKeyFromObject(obj), fresh); err != nil {
+		return nil, rule, false, client.IgnoreNotFound(err)
 	}
+	log.FromContext(ctx).Info("Resource changed since it was evaluated, re-evaluating", "resource", obj.GetName(),
+		"uid", fresh.GetUID(), "resourceVersion", fresh.GetResourceVersion())
 
-	return ctrl.Result{RequeueAfter: requeueAfterTime}, nil
+	// It may be a new object of the same name, or have been relabelled
+	rule, _, ok := r.getRule(ctx, gvk, fresh)
+	if !ok || !hasExpiryFor(fresh, rule) {
+		return nil, rule, false, nil
+	}
+	matches, err := r.matchesFieldSelector(ctx, fresh, rule)
+	return fresh, rule, matches, err
 }
 
-// Get check interval from config map
-func (r *TtlReaperReconciler) getRequeueTimeFromConfigMap(configMap *corev1.ConfigMap) (time.Duration, error) {
+// reapOnce deletes the object if its TTL has expired, a conflict error is returned when the
+// object changed since it was read
+func (r *TtlReaperReconciler) reapOnce(ctx context.Context, obj client.Object, rule gvkRule) (reapOutcome, time.Duration, error) {
+	l := log.FromContext(ctx)
+	gvk := rule.GroupVersionKind()
+	metricLabels := prometheus.Labels{"gvk": gvkLabel(gvk), "namespace": obj.GetNamespace()}
+
+	// Condition start points need the status, fetch it when only metadata is at hand
+	if strings.HasPrefix(rule.TtlStart, TtlStartConditionPrefix) {
+		full, err := r.fullObject(ctx, obj, gvk)
+		if err != nil {
+			return reapSkipped, 0, client.IgnoreNotFound(err)
+		}
+		obj = full
+	}
 
-	// Get the value for the "check-interval" key
-	checkIntervalStr, exists := configMap.Data["check-interval"]
-	if !exists {
-		return 0, fmt.Errorf("check-interval not found in ConfigMap")
+	expirationTime, err := getExpirationTime(obj, rule)
+	if errors.Is(err, errTtlNotStarted) {
+		l.V(1).Info("TTL has not started, skipping", "resource", obj.GetName(), "ttlStart", rule.TtlStart)
+		return reapSkipped, 0, nil
+	}
+	if err != nil {
+		l.Error(err, "Invalid TTL value", "resource", obj.GetName())
+		r.raiseEvent(obj, "Warning", "InvalidTTL", eventActionReap, err.Error())
+		r.notifySkipped(ctx, obj, rule, rule.action, time.Time{}, err, "Invalid TTL")
+		invalidTtlTotal.With(metricLabels).Inc()
+		return reapInvalid, 0, nil
 	}
 
-	checkInterval, err := time.ParseDuration(checkIntervalStr)
+	if remaining := time.Until(expirationTime); remaining > 0 {
+		r.notices.forget(obj.GetUID())
+		r.warnExpiring(ctx, obj, rule, expirationTime, remaining)
+		return reapPending, remaining, nil
+	}
+	if obj.GetDeletionTimestamp() != nil {
+		// Already being deleted, e.g. evicted, a foreground deletion or waiting on finalizers
+		return reapDeletionPending, 0, nil
+	}
+
+	// Only reap expired objects whose condition holds, e.g. in a terminal state
+	if rule.conditionErr != nil {
+		l.V(1).Info("Invalid condition, skipping expired resource", "resource", obj.GetName(), "gvk", gvk.String())
+		r.notifySkipped(ctx, obj, rule, rule.action, expirationTime, rule.conditionErr, "Invalid condition")
+		conditionNotMetTotal.With(metricLabels).Inc()
+		return reapConditionNotMet, 0, nil
+	}
+	if rule.condition != nil {
+		full, err := r.fullObject(ctx, obj, gvk)
+		if err != nil {
+			return reapSkipped, 0, client.IgnoreNotFound(err)
+		}
+		if holds, err := evalCondition(rule.condition, full); !holds {
+			l.Info("Condition doesn't hold, skipping expired resource", "resource", obj.GetName(), "gvk", gvk.String(),
+				"error", err)
+			r.raiseEvent(obj, "Normal", "ConditionNotMet", eventActionReap, "Expired but not deleted as the condition doesn't hold")
+			r.notifySkipped(ctx, obj, rule, rule.action, expirationTime, err, "Condition doesn't hold")
+			conditionNotMetTotal.With(metricLabels).Inc()
+			return reapConditionNotMet, 0, nil
+		}
+		obj = full
+	}
+
+	action, err := objectAction(obj, rule)
 	if err != nil {
-		return 0, fmt.Errorf("invalid check-interval value: %v", err)
+		l.Error(err, "Invalid action annotation", "resource", obj.GetName())
+		r.raiseEvent(obj, "Warning", "InvalidAction", eventActionReap, err.Error())
+		r.notifySkipped(ctx, obj, rule, rule.action, expirationTime, err, "Invalid action annotation")
+		return reapInvalid, 0, nil
+	}
+	if action != v1alpha1.ActionDelete {
+		return r.act(ctx, obj, rule, action, expirationTime)
 	}
 
-	return checkInterval, nil
+	deleteOpts, err := deleteOptions(obj, rule)
+	if err != nil {
+		l.Error(err, "Invalid delete options annotation", "resource", obj.GetName())
+		r.raiseEvent(obj, "Warning", "InvalidDeleteOptions", eventActionReap, err.Error())
+		r.notifySkipped(ctx, obj, rule, action, expirationTime, err, "Invalid delete options annotation")
+		return reapInvalid, 0, nil
+	}
+	// Only delete the object the TTL was evaluated on, not one changed or recreated since
+	uid, resourceVersion := obj.GetUID(), obj.GetResourceVersion()
+	deleteOpts = append(deleteOpts, client.Preconditions{UID: &uid, ResourceVersion: &resourceVersion})
+
+	switch rule.DryRun {
+	case v1alpha1.DryRunClient:
+		l.Info("Dry run, would delete expired resource", "resource", obj.GetName(), "gvk", gvk.String())
+		r.raiseEvent(obj, "Normal", "WouldReap", eventActionReap, "Would be deleted due to expired TTL (dry run)")
+		r.notifySkipped(ctx, obj, rule, action, expirationTime, nil, "Would be deleted due to expired TTL (dry run)")
+		r.audit(ctx, obj, rule, action, expirationTime, audit.OutcomeDryRun, nil)
+		wouldReapTotal.With(metricLabels).Inc()
+		return reapWouldReap, 0, nil
+	case v1alpha1.DryRunServer:
+		// Exercises admission webhooks and finalizers without deleting anything
+		l.Info("Server dry run, would delete expired resource", "resource", obj.GetName(), "gvk", gvk.String())
+		if err := r.Client.Delete(ctx, obj, append(deleteOpts, client.DryRunAll)...); err != nil {
+			if apierrors.IsConflict(err) || apierrors.IsNotFound(err) {
+				return reapSkipped, 0, client.IgnoreNotFound(err)
+			}
+			l.Error(err, "Server dry run delete failed", "resource", obj.GetName())
+			r.raiseEvent(obj, "Warning", "WouldReap", eventActionReap, fmt.Sprintf("Server dry run delete failed: %v", err))
+			reapFailedTotal.With(metricLabels).Inc()
+			return reapFailed, 0, err
+		}
+		r.raiseEvent(obj, "Normal", "WouldReap", eventActionReap, "Would be deleted due to expired TTL (server dry run)")
+		r.notifySkipped(ctx, obj, rule, action, expirationTime, nil, "Would be deleted due to expired TTL (server dry run)")
+		r.audit(ctx, obj, rule, action, expirationTime, audit.OutcomeDryRun, nil)
+		wouldReapTotal.With(metricLabels).Inc()
+		return reapWouldReap, 0, nil
+	}
+
+	// Keep a copy to restore from, an object that can't be archived isn't deleted
+	if r.Archive != nil {
+		err := r.archiveObject(ctx, obj, rule)
+		switch {
+		case apierrors.IsNotFound(err):
+			r.forgetObject(obj.GetUID())
+			return reapSkipped, 0, nil
+		case apierrors.IsConflict(err):
+			return reapSkipped, 0, err
+		case err != nil:
+			l.Error(err, "Failed to archive resource", "resource", obj.GetName())
+			r.raiseEvent(obj, "Warning", "ArchiveFailed", eventActionReap, fmt.Sprintf("Failed to archive expired object, not deleting it: %v", err))
+			r.notifyOutcome(ctx, obj, rule, v1alpha1.ActionDelete, expirationTime, err, "Failed to archive expired object")
+			r.audit(ctx, obj, rule, v1alpha1.ActionDelete, expirationTime, audit.OutcomeFailed, err)
+			reapFailedTotal.With(metricLabels).Inc()
+			return reapFailed, 0, err
+		}
+		archivedTotal.With(metricLabels).Inc()
+	}
+
+	l.Info("Deleting expired resource", "resource", obj.GetName(), "gvk", gvk.String())
+	err = r.Client.Delete(ctx, obj, deleteOpts...)
+	switch {
+	case apierrors.IsNotFound(err):
+		// Already gone, e.g. reaped by the expiry controller during the sweep
+		l.V(1).Info("Expired resource already deleted", "resource", obj.GetName(), "gvk", gvk.String())
+		r.forgetObject(obj.GetUID())
+		return reapSkipped, 0, nil
+	case apierrors.IsConflict(err):
+		return reapSkipped, 0, err
+	case err != nil:
+		l.Error(err, "Failed to delete resource", "resource", obj.GetName())
+		r.raiseEvent(obj, "Warning", "ReapFailed", eventActionReap, fmt.Sprintf("Failed to delete expired object: %v", err))
+		r.notifyOutcome(ctx, obj, rule, v1alpha1.ActionDelete, expirationTime, err, "Failed to delete expired object")
+		r.audit(ctx, obj, rule, v1alpha1.ActionDelete, expirationTime, audit.OutcomeFailed, err)
+		reapFailedTotal.With(metricLabels).Inc()
+		return reapFailed, 0, err
+	}
+	reapedTotal.With(metricLabels).Inc()
+	r.raiseEvent(obj, "Normal", "ReapedOnTTL", eventActionReap, "Deleted due to expired TTL")
+	r.notifyOutcome(ctx, obj, rule, v1alpha1.ActionDelete, expirationTime, nil, "Deleted due to expired TTL")
+	r.audit(ctx, obj, rule, v1alpha1.ActionDelete, expirationTime, audit.OutcomeSucceeded, nil)
+
+	return reapReaped, 0, nil
 }
 
-// Get name prefix from config map
-func (r *TtlReaperReconciler) getNamePrefix(configMap *corev1.ConfigMap) string {
-	// Get the value for the "name-prefix" key if it exists
-	namePrefix := configMap.Data["name-prefix"]
+// act takes an action other than delete on an expired object
+func (r *TtlReaperReconciler) act(ctx context.Context, obj client.Object, rule gvkRule,
+	action v1alpha1.ExpiryAction, expirationTime time.Time) (reapOutcome, time.Duration, error) {
+	l := log.FromContext(ctx).WithValues("resource", obj.GetName(), "gvk", rule.String(), "action", action)
+	actionLabels := prometheus.Labels{"gvk": gvkLabel(rule.GroupVersionKind()), "namespace": obj.GetNamespace(), "action": string(action)}
+	event := actionEvents[action]
 
-	return namePrefix
+	// Actions keeping the object are only taken once per expiry
+	if !removes(action) && actioned(obj, expirationTime) {
+		return reapSkipped, 0, nil
+	}
+
+	switch rule.DryRun {
+	case v1alpha1.DryRunClient:
+		l.Info("Dry run, would take action on expired resource")
+		r.raiseEvent(obj, "Normal", "WouldReap", eventActionReap, fmt.Sprintf("Would take the %s action due to expired TTL (dry run)", action))
+		r.notifySkipped(ctx, obj, rule, action, expirationTime, nil, fmt.Sprintf("Would take the %s action due to expired TTL (dry run)", action))
+		r.audit(ctx, obj, rule, action, expirationTime, audit.OutcomeDryRun, nil)
+		wouldReapTotal.With(prometheus.Labels{"gvk": actionLabels["gvk"], "namespace": obj.GetNamespace()}).Inc()
+		return reapWouldReap, 0, nil
+	case v1alpha1.DryRunServer:
+		l.Info("Server dry run, would take action on expired resource")
+		if err := r.takeAction(ctx, obj, rule, action, true); err != nil {
+			l.Error(err, "Server dry run action failed")
+			r.raiseEvent(obj, "Warning", "WouldReap", eventActionReap, fmt.Sprintf("Server dry run %s action failed: %v", action, err))
+			actionFailedTotal.With(actionLabels).Inc()
+			return reapFailed, 0, err
+		}
+		r.raiseEvent(obj, "Normal", "WouldReap", eventActionReap, fmt.Sprintf("Would take the %s action due to expired TTL (server dry run)", action))
+		r.notifySkipped(ctx, obj, rule, action, expirationTime, nil, fmt.Sprintf("Would take the %s action due to expired TTL (server dry run)", action))
+		r.audit(ctx, obj, rule, action, expirationTime, audit.OutcomeDryRun, nil)
+		wouldReapTotal.With(prometheus.Labels{"gvk": actionLabels["gvk"], "namespace": obj.GetNamespace()}).Inc()
+		return reapWouldReap, 0, nil
+	}
+
+	l.Info("Taking action on expired resource")
+	if err := r.takeAction(ctx, obj, rule, action, false); err != nil {
+		// e.g. an eviction refused by a PodDisruptionBudget, retried on the next sweep
+		l.Error(err, "Failed to take action on resource")
+		r.raiseEvent(obj, "Warning", "ActionFailed", eventActionReap, fmt.Sprintf("%s action failed: %v", action, err))
+		r.notifyOutcome(ctx, obj, rule, action, expirationTime, err, fmt.Sprintf("%s action failed", action))
+		r.audit(ctx, obj, rule, action, expirationTime, audit.OutcomeFailed, err)
+		actionFailedTotal.With(actionLabels).Inc()
+		return reapFailed, 0, err
+	}
+	r.raiseEvent(obj, "Normal", event.reason, eventActionReap, event.message)
+	r.notifyOutcome(ctx, obj, rule, action, expirationTime, nil, event.message)
+	r.audit(ctx, obj, rule, action, expirationTime, audit.OutcomeSucceeded, nil)
+	actionsTotal.With(actionLabels).Inc()
+
+	return reapActioned, 0, nil
+}
+
+// fullObject returns the object with its spec and status, fetching it when only metadata is at hand
+func (r *TtlReaperReconciler) fullObject(ctx context.Context, obj client.Object, gvk schema.GroupVersionKind) (*unstructured.Unstructured, error) {
+	if full, isUnstructured := obj.(*unstructured.Unstructured); isUnstructured {
+		return full, nil
+	}
+	full := &unstructured.Unstructured{}
+	full.SetGroupVersionKind(gvk)
+	if err := r.Get(ctx, client.ObjectKeyFromObject(obj), full); err != nil {
+		return nil, err
+	}
+	return full, nil
 }
 
-// Raise event in operator namespace
-func (r *TtlReaperReconciler) raiseEvent(obj client.Object, eventType, reason, message string) {
+// raiseEvent records an event on an object in its namespace, events of cluster-scoped objects
+// are recorded in the operator namespace
+func (r *TtlReaperReconciler) raiseEvent(obj client.Object, eventType, reason, action, message string) {
 	eventRef := &corev1.ObjectReference{
-		Kind:       obj.GetObjectKind().GroupVersionKind().Kind,
-		APIVersion: obj.GetObjectKind().GroupVersionKind().GroupVersion().String(),
-		Name:       obj.GetName(),
-		Namespace:  OperatorNamespace,
-		UID:        obj.GetUID(),
+		Kind:            obj.GetObjectKind().GroupVersionKind().Kind,
+		APIVersion:      obj.GetObjectKind().GroupVersionKind().GroupVersion().String(),
+		Name:            obj.GetName(),
+		Namespace:       cmp.Or(obj.GetNamespace(), OperatorNamespace),
+		UID:             obj.GetUID(),
+		ResourceVersion: obj.GetResourceVersion(),
 	}
 
-	r.Recorder.Event(eventRef, eventType, reason, message)
+	r.Recorder.Eventf(eventRef, nil, eventType, reason, action, "%s", message)
 }
 
-// Only use configmap named as per param
-func nameMatchPredicate(name string) predicate.Predicate {
-	return predicate.NewPredicateFuncs(func(object client.Object) bool {
-		return object.GetName() == name && object.GetNamespace() == OperatorNamespace
-	})
-}
+func (r *TtlReaperReconciler) SetupWithManager(mgr ctrl.Manager, configurationNames ...string) error {
+	r.ConfigurationNames = configurationNames
+	r.apiReader = mgr.GetAPIReader()
+	discoveryClient, err := discovery.NewDiscoveryClientForConfig(mgr.GetConfig())
+	if err != nil {
+		return err
+	}
+	r.discovery = discoveryClient
+
+	// Reap objects at their expiry, kinds are watched as they are configured
+	if err := r.setupExpiryController(mgr); err != nil {
+		return err
+	}
 
-func (r *TtlReaperReconciler) SetupWithManager(mgr ctrl.Manager, configurationName string) error {
-	r.ConfigurationName = configurationName
+	// Dispatch the notifications in the background
+	if r.Notifier != nil {
+		r.notifications = make(chan []queuedNotification, notificationQueueSize)
+		if err := mgr.Add(manager.RunnableFunc(r.dispatchQueued)); err != nil {
+			return err
+		}
+	}
 
-	// Watch the ConfigMap for changes (GVKs to watch)
-	return ctrl.NewControllerManagedBy(mgr).
+	// Watch the ConfigMaps for changes (GVKs to watch), each configuration is a reconcile
+	// request of its own so configurations are swept independently
+	b := ctrl.NewControllerManagedBy(mgr).
 		For(&corev1.ConfigMap{},
 			builder.WithPredicates(
 				predicate.ResourceVersionChangedPredicate{},
-				nameMatchPredicate(configurationName),
+				r.configurationPredicate(),
 			)).
-		Complete(r)
+		WithOptions(controller.Options{MaxConcurrentReconciles: maxConcurrentConfigurations})
+
+	// Watch the TtlReaperPolicies too when the CRD is installed, otherwise only ConfigMaps are used
+	policyGVK := v1alpha1.GroupVersion.WithKind("TtlReaperPolicy")
+	if _, err := mgr.GetRESTMapper().RESTMapping(policyGVK.GroupKind(), policyGVK.Version); err != nil {
+		mgr.GetLogger().Info("TtlReaperPolicy CRD not installed, using ConfigMaps only", "error", err.Error())
+	} else {
+		r.policyEnabled = true
+		b = b.Watches(&v1alpha1.TtlReaperPolicy{},
+			handler.EnqueueRequestsFromMapFunc(mapPolicyToConfiguration),
+			builder.WithPredicates(
+				predicate.Or[client.Object](predicate.GenerationChangedPredicate{}, predicate.LabelChangedPredicate{}),
+				r.configurationPredicate(),
+			))
+	}
+
+	// Tenant policies are merged with the configurations on every sweep, re-sweep when they change
+	tenantGVK := v1alpha1.GroupVersion.WithKind("TtlReaperTenantPolicy")
+	if _, err := mgr.GetRESTMapper().RESTMapping(tenantGVK.GroupKind(), tenantGVK.Version); err != nil {
+		mgr.GetLogger().Info("TtlReaperTenantPolicy CRD not installed, tenant policies are disabled", "error", err.Error())
+	} else {
+		r.tenantsEnabled = true
+		b = b.Watches(&v1alpha1.TtlReaperTenantPolicy{},
+			handler.EnqueueRequestsFromMapFunc(r.mapToConfigurations),
+			builder.WithPredicates(predicate.GenerationChangedPredicate{}))
+	}
+
+	return b.Complete(r)
 }
@@ -21,10 +21,19 @@ import (
 	"kubettlreaper/test/utils"
 	"os"
 	"os/exec"
+	"strconv"
+	"time"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"kubettlreaper/api/v1alpha1"
 )
 
 const namePrefix = "tmp-ttl-"
@@ -115,6 +124,69 @@ var _ = Describe("TtlReaper Controller", Ordered, func() {
 		})
 	})
 
+	Context("When creating a Secret with an expires-at annotation in the past", func() {
+		secretName := namePrefix + "cortana"
+		It("should exist with an expiry", func() {
+			By("Creating the Secret without a TTL label")
+			expiresAt := time.Now().Add(-time.Minute).UTC().Format(time.RFC3339)
+			err := utils.CreateSecretWithExpiry(ctx, k8sClient, secretName, namespace, expiresAt, "")
+			Expect(err).NotTo(HaveOccurred())
+		})
+		It("should be deleted by the operator", func() {
+			By("Waiting for the Secret to be deleted")
+			gvk := schema.GroupVersionKind{
+				Group:   "",
+				Version: "v1",
+				Kind:    "Secret",
+			}
+			utils.WaitForDeleted(ctx, k8sClient, namespace, secretName, gvk, BeTrue(), "Delete")
+		})
+	})
+
+	Context("When creating a Secret with an expires-at annotation in the future and a TTL of 1s", func() {
+		secretName := namePrefix + "arbiter"
+		It("should exist with an expiry and a TTL", func() {
+			By("Creating the Secret with both the annotation and the label")
+			expiresAt := strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)
+			err := utils.CreateSecretWithExpiry(ctx, k8sClient, secretName, namespace, expiresAt, "1s")
+			Expect(err).NotTo(HaveOccurred())
+		})
+		It("should not be deleted as the annotation takes precedence", func() {
+			By("Waiting for the Secret not to be deleted")
+			gvk := schema.GroupVersionKind{
+				Group:   "",
+				Version: "v1",
+				Kind:    "Secret",
+			}
+			utils.WaitForKept(ctx, k8sClient, namespace, secretName, gvk, "Skip delete")
+		})
+	})
+
+	Context("When shortening the TTL of an existing Secret", func() {
+		secretName := namePrefix + "johnson"
+		It("should exist with a long TTL", func() {
+			By("Creating the Secret")
+			err := utils.CreateSecret(ctx, k8sClient, secretName, namespace, "1h")
+			Expect(err).NotTo(HaveOccurred())
+		})
+		It("should be deleted once the TTL label is edited to an expired value", func() {
+			By("Relabelling the Secret")
+			secret := &corev1.Secret{}
+			err := k8sClient.Get(ctx, types.NamespacedName{Namespace: namespace, Name: secretName}, secret)
+			Expect(err).NotTo(HaveOccurred())
+			secret.Labels[utils.TtlLabel] = "1s"
+			Expect(k8sClient.Update(ctx, secret)).To(Succeed())
+
+			By("Waiting for the Secret to be deleted")
+			gvk := schema.GroupVersionKind{
+				Group:   "",
+				Version: "v1",
+				Kind:    "Secret",
+			}
+			utils.WaitForDeleted(ctx, k8sClient, namespace, secretName, gvk, BeTrue(), "Delete")
+		})
+	})
+
 	Context("When creating a Secret with a TTL of 10s and invalid namePrefix", func() {
 		secretName := "master-chief"
 		It("should exist with a TTL", func() {
@@ -129,7 +201,123 @@ var _ = Describe("TtlReaper Controller", Ordered, func() {
 				Version: "v1",
 				Kind:    "Secret",
 			}
-			utils.WaitForDeleted(ctx, k8sClient, namespace, secretName, gvk, BeFalse(), "Skip delete")
+			utils.WaitForKept(ctx, k8sClient, namespace, secretName, gvk, "Skip delete")
+		})
+	})
+
+	Context("When a TtlReaperPolicy of the same name is created", func() {
+		secretName := namePrefix + "cortana"
+		It("should take over from the ConfigMap", func() {
+			By("Creating the TtlReaperPolicy")
+			policy, err := utils.CreateTtlReaperPolicy(ctx, k8sClient, utils.ConfigurationName, namespace, namePrefix, "5s")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(policy).NotTo(BeNil())
+
+			By("Checking the policy is valid")
+			Eventually(func() bool {
+				err := k8sClient.Get(ctx, types.NamespacedName{Name: utils.ConfigurationName}, policy)
+				return err == nil && meta.IsStatusConditionTrue(policy.Status.Conditions, v1alpha1.ConditionValid)
+			}, "20s", "1s").Should(BeTrue())
+		})
+		It("should delete a Secret with an expired TTL", func() {
+			By("Creating the Secret")
+			err := utils.CreateSecret(ctx, k8sClient, secretName, namespace, "1s")
+			Expect(err).NotTo(HaveOccurred())
+
+			By("Waiting for the Secret to be deleted")
+			gvk := schema.GroupVersionKind{
+				Group:   "",
+				Version: "v1",
+				Kind:    "Secret",
+			}
+			utils.WaitForDeleted(ctx, k8sClient, namespace, secretName, gvk, BeTrue(), "Delete")
+		})
+		It("should report the last sweep in the policy status", func() {
+			policy := &v1alpha1.TtlReaperPolicy{}
+			Eventually(func() bool {
+				err := k8sClient.Get(ctx, types.NamespacedName{Name: utils.ConfigurationName}, policy)
+				return err == nil && policy.Status.LastSweepSummary != nil &&
+					meta.IsStatusConditionTrue(policy.Status.Conditions, v1alpha1.ConditionLastSweep) &&
+					meta.IsStatusConditionFalse(policy.Status.Conditions, v1alpha1.ConditionErrors)
+			}, "20s", "1s").Should(BeTrue())
+			Expect(policy.Status.LastSweepSummary.Kinds).To(Equal(int32(3)))
+		})
+	})
+
+	Context("When a TtlReaperTenantPolicy gives Secrets in its namespace a default TTL", func() {
+		secretName := namePrefix + "tenant-arbiter"
+		It("should be accepted", func() {
+			By("Creating the TtlReaperTenantPolicy")
+			policy, err := utils.CreateTtlReaperTenantPolicy(ctx, k8sClient, "tenant", namespace,
+				map[string]string{"app": "tenant"}, "1s")
+			Expect(err).NotTo(HaveOccurred())
+
+			By("Checking the policy is valid")
+			Eventually(func() bool {
+				err := k8sClient.Get(ctx, client.ObjectKeyFromObject(policy), policy)
+				return err == nil && meta.IsStatusConditionTrue(policy.Status.Conditions, v1alpha1.ConditionValid)
+			}, "20s", "1s").Should(BeTrue())
+		})
+		It("should delete a selected Secret without a TTL label", func() {
+			By("Creating the Secret")
+			secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{
+				Name:      secretName,
+				Namespace: namespace,
+				Labels:    map[string]string{"app": "tenant"},
+			}}
+			Expect(k8sClient.Create(ctx, secret)).To(Succeed())
+
+			By("Waiting for the Secret to be deleted")
+			gvk := schema.GroupVersionKind{
+				Group:   "",
+				Version: "v1",
+				Kind:    "Secret",
+			}
+			utils.WaitForDeleted(ctx, k8sClient, namespace, secretName, gvk, BeTrue(), "Delete")
+		})
+	})
+
+	Context("When a Secret with an expired TTL is in a protected namespace", func() {
+		secretName := namePrefix + "sentinel"
+		It("should not be deleted unless the namespace is opted into", func() {
+			By("Creating the Secret in kube-system")
+			err := utils.CreateSecret(ctx, k8sClient, secretName, "kube-system", "1s")
+			Expect(err).NotTo(HaveOccurred())
+
+			By("Waiting to make sure the Secret is not deleted")
+			gvk := schema.GroupVersionKind{
+				Group:   "",
+				Version: "v1",
+				Kind:    "Secret",
+			}
+			utils.WaitForKept(ctx, k8sClient, "kube-system", secretName, gvk, "Skip delete")
+		})
+	})
+
+	Context("When a second configuration is marked with the configuration label", func() {
+		secretName := "other-ttl-guilty-spark"
+		It("should be discovered and sweep independently", func() {
+			By("Creating the marked ConfigMap")
+			_, err := utils.CreateMarkedConfigMap(ctx, k8sClient, "other-config", namespace, "other-ttl-", "5s")
+			Expect(err).NotTo(HaveOccurred())
+
+			By("Checking the configuration is used")
+			err = utils.CheckEvent(ctx, k8sClient, "other-config", namespace, "Normal", "ValidConfig",
+				"Processing GVKs from configMap")
+			Expect(err).NotTo(HaveOccurred())
+		})
+		It("should delete a Secret matching its name prefix", func() {
+			By("Creating the Secret")
+			err := utils.CreateSecret(ctx, k8sClient, secretName, namespace, "1s")
+			Expect(err).NotTo(HaveOccurred())
+
+			By("Waiting for the Secret to be deleted")
+			gvk := schema.GroupVersionKind{
+				Group:   "",
+				Version: "v1",
+				Kind:    "Secret",
+			}
+			utils.WaitForDeleted(ctx, k8sClient, namespace, secretName, gvk, BeTrue(), "Delete")
 		})
 	})
 
@@ -0,0 +1,136 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"kubettlreaper/api/v1alpha1"
+	"kubettlreaper/internal/notify"
+	"kubettlreaper/internal/ttl"
+)
+
+const (
+	// ExpiresInAnnotation is stamped with the warning threshold an object last crossed, e.g. 1h
+	ExpiresInAnnotation = "kubettlreaper.samir.io/expires-in"
+	// WarnedForAnnotation records the expiry the warning was given for, a renewal resets the warnings
+	WarnedForAnnotation = "kubettlreaper.samir.io/warned-for"
+)
+
+// parseWarnBefore parses warning thresholds, longest first
+func parseWarnBefore(values []string) ([]time.Duration, error) {
+	thresholds := make([]time.Duration, 0, len(values))
+	for _, value := range values {
+		threshold, err := ttl.ParseDuration(value)
+		if err != nil || threshold <= 0 {
+			return nil, fmt.Errorf("invalid warn-before %q: must be a positive duration", value)
+		}
+		thresholds = append(thresholds, threshold)
+	}
+	slices.Sort(thresholds)
+	slices.Reverse(thresholds)
+
+	return slices.Compact(thresholds), nil
+}
+
+// crossedThreshold returns the shortest threshold an object expiring in remaining is within
+func crossedThreshold(thresholds []time.Duration, remaining time.Duration) (time.Duration, bool) {
+	for i := len(thresholds) - 1; i >= 0; i-- {
+		if remaining <= thresholds[i] {
+			return thresholds[i], true
+		}
+	}
+	return 0, false
+}
+
+// untilNextWarning returns how long until an object expiring in remaining crosses its next threshold
+func untilNextWarning(thresholds []time.Duration, remaining time.Duration) (time.Duration, bool) {
+	for _, threshold := range thresholds {
+		if remaining > threshold {
+			return remaining - threshold, true
+		}
+	}
+	return 0, false
+}
+
+// warned reports whether a warning was already given for a threshold, or a shorter one, of an expiry
+func warned(obj client.Object, expirationTime time.Time, threshold time.Duration) bool {
+	annotations := obj.GetAnnotations()
+	warnedFor, err := time.Parse(time.RFC3339, annotations[WarnedForAnnotation])
+	if err != nil || !warnedFor.Equal(expirationTime.Truncate(time.Second)) {
+		return false
+	}
+	expiresIn, err := ttl.ParseDuration(annotations[ExpiresInAnnotation])
+	return err == nil && expiresIn <= threshold
+}
+
+// warnExpiring warns once per threshold that an object expires soon: an ExpiringSoon event,
+// the expires-in annotation and a notification. Nothing is sent in dry run
+func (r *TtlReaperReconciler) warnExpiring(ctx context.Context, obj client.Object, rule gvkRule,
+	expirationTime time.Time, remaining time.Duration) {
+	threshold, crossed := crossedThreshold(rule.warnBefore, remaining)
+	if !crossed || rule.DryRun != v1alpha1.DryRunNone || warned(obj, expirationTime, threshold) {
+		return
+	}
+
+	action := rule.action
+	if annotated, err := objectAction(obj, rule); err == nil {
+		action = annotated
+	}
+	message := fmt.Sprintf("Expires in %s at %s, the %s action is then taken", ttl.FormatDuration(remaining),
+		expirationTime.UTC().Format(time.RFC3339), action)
+
+	// Only recorded once every receiver accepted the notification, so a failed one is retried
+	notification := newNotification(notify.TypeExpiring, obj, rule, action, expirationTime, notify.ResultPending, nil, message)
+	key := deliveryKey{uid: obj.GetUID(), id: warningID(expirationTime, threshold)}
+	r.notifyOnce(ctx, notification, key, func(ctx context.Context) {
+		r.recordWarning(ctx, obj, rule, expirationTime, threshold, message)
+	})
+}
+
+// warningID identifies the warning about a threshold of an expiry
+func warningID(expirationTime time.Time, threshold time.Duration) string {
+	return fmt.Sprintf("%s/%s", expirationTime.UTC().Truncate(time.Second).Format(time.RFC3339), threshold)
+}
+
+// recordWarning marks an object as warned about a threshold and expiry, and raises an event
+func (r *TtlReaperReconciler) recordWarning(ctx context.Context, obj client.Object, rule gvkRule,
+	expirationTime time.Time, threshold time.Duration, message string) {
+	l := log.FromContext(ctx).WithValues("resource", obj.GetName(), "gvk", rule.String())
+	target := &unstructured.Unstructured{}
+	target.SetGroupVersionKind(rule.GroupVersionKind())
+	target.SetNamespace(obj.GetNamespace())
+	target.SetName(obj.GetName())
+	patch := client.RawPatch(types.MergePatchType, []byte(fmt.Sprintf(`{"metadata":{"annotations":{%q:%q,%q:%q}}}`,
+		ExpiresInAnnotation, ttl.FormatDuration(threshold),
+		WarnedForAnnotation, expirationTime.UTC().Truncate(time.Second).Format(time.RFC3339))))
+	if err := r.Patch(ctx, target, patch, client.FieldOwner(FieldOwner)); err != nil {
+		l.Error(err, "Failed to record expiry warning")
+		return
+	}
+
+	l.Info("Resource expires soon", "expiresAt", expirationTime, "threshold", threshold)
+	r.raiseEvent(obj, "Warning", "ExpiringSoon", eventActionReap, message)
+}
@@ -0,0 +1,256 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/events"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"kubettlreaper/api/v1alpha1"
+	"kubettlreaper/internal/notify"
+)
+
+// recordingNotifier keeps the notifications it is sent
+type recordingNotifier struct {
+	notifications []notify.Notification
+	err           error
+}
+
+func (n *recordingNotifier) Notify(_ context.Context, notifications []notify.Notification) error {
+	if n.err != nil {
+		return n.err
+	}
+	n.notifications = append(n.notifications, notifications...)
+	return nil
+}
+
+var _ = Describe("Expiry warnings", func() {
+	thresholds := []time.Duration{24 * time.Hour, time.Hour}
+
+	It("should parse thresholds longest first", func() {
+		parsed, err := parseWarnBefore([]string{"1h", "1d", "24h"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(parsed).To(Equal(thresholds))
+
+		_, err = parseWarnBefore([]string{"0s"})
+		Expect(err).To(HaveOccurred())
+		_, err = parseWarnBefore([]string{"soon"})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should find the threshold crossed and the next one", func() {
+		_, crossed := crossedThreshold(thresholds, 48*time.Hour)
+		Expect(crossed).To(BeFalse())
+		threshold, crossed := crossedThreshold(thresholds, 12*time.Hour)
+		Expect(crossed).To(BeTrue())
+		Expect(threshold).To(Equal(24 * time.Hour))
+		threshold, _ = crossedThreshold(thresholds, 30*time.Minute)
+		Expect(threshold).To(Equal(time.Hour))
+
+		next, pending := untilNextWarning(thresholds, 48*time.Hour)
+		Expect(pending).To(BeTrue())
+		Expect(next).To(Equal(24 * time.Hour))
+		next, _ = untilNextWarning(thresholds, 12*time.Hour)
+		Expect(next).To(Equal(11 * time.Hour))
+		_, pending = untilNextWarning(thresholds, 30*time.Minute)
+		Expect(pending).To(BeFalse())
+	})
+
+	Context("when an object crosses a threshold", func() {
+		var (
+			reconciler *TtlReaperReconciler
+			notifier   *recordingNotifier
+			recorder   *events.FakeRecorder
+			rule       gvkRule
+			key        = client.ObjectKey{Namespace: "default", Name: "tmp-ttl-preview"}
+		)
+
+		BeforeEach(func() {
+			scheme := runtime.NewScheme()
+			Expect(corev1.AddToScheme(scheme)).To(Succeed())
+			pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+				Namespace:   key.Namespace,
+				Name:        key.Name,
+				Annotations: map[string]string{OwnerAnnotation: "team-preview"},
+			}}
+			c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(pod).Build()
+
+			notifier = &recordingNotifier{}
+			recorder = events.NewFakeRecorder(10)
+			reconciler = &TtlReaperReconciler{Client: c, Recorder: recorder, apiReader: c, Notifier: notifier}
+			rule = gvkRule{Version: "v1", Kind: "Pod", DryRun: v1alpha1.DryRunNone, action: v1alpha1.ActionDelete,
+				warnBefore: thresholds}
+		})
+
+		// current reads the pod's metadata
+		current := func() *metav1.PartialObjectMetadata {
+			obj := &metav1.PartialObjectMetadata{}
+			obj.SetGroupVersionKind(rule.GroupVersionKind())
+			Expect(reconciler.Get(ctx, key, obj)).To(Succeed())
+			return obj
+		}
+
+		It("should warn once per threshold and expiry", func() {
+			expirationTime := time.Now().Add(12 * time.Hour)
+			reconciler.warnExpiring(ctx, current(), rule, expirationTime, time.Until(expirationTime))
+			Expect(notifier.notifications).To(HaveLen(1))
+			Expect(notifier.notifications[0].Type).To(Equal(notify.TypeExpiring))
+			Expect(notifier.notifications[0].Owner).To(Equal("team-preview"))
+			Expect(recorder.Events).To(Receive(HavePrefix("Warning ExpiringSoon")))
+			Expect(current().GetAnnotations()).To(HaveKeyWithValue(ExpiresInAnnotation, "1d"))
+
+			// The next sweep doesn't repeat it
+			reconciler.warnExpiring(ctx, current(), rule, expirationTime, time.Until(expirationTime))
+			Expect(notifier.notifications).To(HaveLen(1))
+
+			// The shorter threshold warns again
+			reconciler.warnExpiring(ctx, current(), rule, expirationTime, 30*time.Minute)
+			Expect(notifier.notifications).To(HaveLen(2))
+			Expect(current().GetAnnotations()).To(HaveKeyWithValue(ExpiresInAnnotation, "1h"))
+
+			// A renewal resets the warnings
+			renewed := expirationTime.Add(12 * time.Hour)
+			reconciler.warnExpiring(ctx, current(), rule, renewed, 20*time.Hour)
+			Expect(notifier.notifications).To(HaveLen(3))
+		})
+
+		It("should retry a warning whose notification failed", func() {
+			notifier.err = context.DeadlineExceeded
+			expirationTime := time.Now().Add(30 * time.Minute)
+			reconciler.warnExpiring(ctx, current(), rule, expirationTime, time.Until(expirationTime))
+			Expect(current().GetAnnotations()).NotTo(HaveKey(ExpiresInAnnotation))
+
+			notifier.err = nil
+			reconciler.warnExpiring(ctx, current(), rule, expirationTime, time.Until(expirationTime))
+			Expect(notifier.notifications).To(HaveLen(1))
+			Expect(current().GetAnnotations()).To(HaveKey(WarnedForAnnotation))
+		})
+
+		It("should only record a batched warning once the batch is delivered", func() {
+			notifier.err = context.DeadlineExceeded
+			expirationTime := time.Now().Add(30 * time.Minute)
+			sweepCtx, batch := withNotificationBatch(ctx)
+			reconciler.warnExpiring(sweepCtx, current(), rule, expirationTime, time.Until(expirationTime))
+			reconciler.flushNotifications(ctx, batch)
+			Expect(current().GetAnnotations()).NotTo(HaveKey(WarnedForAnnotation))
+			Expect(recorder.Events).NotTo(Receive())
+
+			notifier.err = nil
+			sweepCtx, batch = withNotificationBatch(ctx)
+			reconciler.warnExpiring(sweepCtx, current(), rule, expirationTime, time.Until(expirationTime))
+			Expect(current().GetAnnotations()).NotTo(HaveKey(WarnedForAnnotation))
+			reconciler.flushNotifications(ctx, batch)
+			Expect(notifier.notifications).To(HaveLen(1))
+			Expect(current().GetAnnotations()).To(HaveKey(WarnedForAnnotation))
+			Expect(recorder.Events).To(Receive(HavePrefix("Warning ExpiringSoon")))
+		})
+
+		It("should only send a failed warning again to the receivers that didn't accept it", func() {
+			first, second := &recordingNotifier{}, &recordingNotifier{err: context.DeadlineExceeded}
+			reconciler.Notifier = notify.Multi{first, second}
+			expirationTime := time.Now().Add(30 * time.Minute)
+			reconciler.warnExpiring(ctx, current(), rule, expirationTime, time.Until(expirationTime))
+			Expect(first.notifications).To(HaveLen(1))
+			Expect(current().GetAnnotations()).NotTo(HaveKey(WarnedForAnnotation))
+
+			second.err = nil
+			reconciler.warnExpiring(ctx, current(), rule, expirationTime, time.Until(expirationTime))
+			Expect(first.notifications).To(HaveLen(1))
+			Expect(second.notifications).To(HaveLen(1))
+			Expect(current().GetAnnotations()).To(HaveKey(WarnedForAnnotation))
+		})
+
+		It("should not send a warning claimed by the sweep again from the expiry controller", func() {
+			reconciler.notifications = make(chan []queuedNotification, 2)
+			expirationTime := time.Now().Add(30 * time.Minute)
+			sweepCtx, batch := withNotificationBatch(ctx)
+			reconciler.warnExpiring(sweepCtx, current(), rule, expirationTime, time.Until(expirationTime))
+			reconciler.warnExpiring(ctx, current(), rule, expirationTime, time.Until(expirationTime))
+			reconciler.flushNotifications(ctx, batch)
+			Expect(reconciler.notifications).To(HaveLen(1))
+
+			// A failed dispatch releases the claim, so the next sweep sends it again
+			notifier.err = context.DeadlineExceeded
+			reconciler.dispatch(ctx, <-reconciler.notifications)
+			notifier.err = nil
+			reconciler.warnExpiring(ctx, current(), rule, expirationTime, time.Until(expirationTime))
+			Expect(reconciler.notifications).To(HaveLen(1))
+		})
+
+		It("should queue the batch of a sweep for the notification worker", func() {
+			reconciler.notifications = make(chan []queuedNotification, 1)
+			expirationTime := time.Now().Add(30 * time.Minute)
+			sweepCtx, batch := withNotificationBatch(ctx)
+			reconciler.warnExpiring(sweepCtx, current(), rule, expirationTime, time.Until(expirationTime))
+			reconciler.notify(sweepCtx, newNotification(notify.TypeReaped, current(), rule, v1alpha1.ActionDelete,
+				expirationTime, notify.ResultSucceeded, nil, "Deleted"))
+			reconciler.flushNotifications(ctx, batch)
+			Expect(notifier.notifications).To(BeEmpty())
+
+			queued := <-reconciler.notifications
+			Expect(queued).To(HaveLen(2))
+			reconciler.dispatch(ctx, queued)
+			Expect(notifier.notifications).To(HaveLen(2))
+			Expect(current().GetAnnotations()).To(HaveKey(WarnedForAnnotation))
+		})
+
+		It("should queue a warning for the notification worker and drop it when the queue is full", func() {
+			reconciler.notifications = make(chan []queuedNotification, 1)
+			expirationTime := time.Now().Add(30 * time.Minute)
+			reconciler.notify(ctx, newNotification(notify.TypeReaped, current(), rule, v1alpha1.ActionDelete,
+				expirationTime, notify.ResultSucceeded, nil, "Deleted"))
+			dropped := testutil.ToFloat64(notificationDroppedTotal)
+			reconciler.warnExpiring(ctx, current(), rule, expirationTime, time.Until(expirationTime))
+			Expect(testutil.ToFloat64(notificationDroppedTotal)).To(Equal(dropped + 1))
+			Expect(notifier.notifications).To(BeEmpty())
+
+			// Sent by the worker, the warning is recorded once delivered
+			workerCtx, cancel := context.WithCancel(ctx)
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				_ = reconciler.dispatchQueued(workerCtx)
+			}()
+			Eventually(func() int { return len(reconciler.notifications) }).Should(BeZero())
+			reconciler.warnExpiring(ctx, current(), rule, expirationTime, time.Until(expirationTime))
+			Eventually(func() int { return len(reconciler.notifications) }).Should(BeZero())
+			cancel()
+			<-done
+
+			Expect(notifier.notifications).To(HaveLen(2))
+			Expect(notifier.notifications[1].Type).To(Equal(notify.TypeExpiring))
+			Expect(current().GetAnnotations()).To(HaveKey(WarnedForAnnotation))
+		})
+
+		It("should not warn in dry run", func() {
+			rule.DryRun = v1alpha1.DryRunClient
+			expirationTime := time.Now().Add(30 * time.Minute)
+			reconciler.warnExpiring(ctx, current(), rule, expirationTime, time.Until(expirationTime))
+			Expect(notifier.notifications).To(BeEmpty())
+		})
+	})
+})
@@ -0,0 +1,196 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/uuid"
+)
+
+// Content modes of the CloudEvents HTTP binding
+const (
+	// ModeBinary sends the data as the body and the attributes as ce- headers
+	ModeBinary = "binary"
+	// ModeStructured sends the whole event as an application/cloudevents+json body
+	ModeStructured = "structured"
+)
+
+const (
+	// cloudEventsSpecVersion is the CloudEvents version of the events
+	cloudEventsSpecVersion = "1.0"
+	// EventTypePrefix prefixes the notification type to make the event type, e.g. io.kubettlreaper.object.reaped
+	EventTypePrefix = "io.kubettlreaper.object."
+	// DefaultEventSource is the source attribute of the events when none is configured
+	DefaultEventSource = "/kubettlreaper"
+)
+
+// Event is a CloudEvent in the structured JSON format
+type Event struct {
+	SpecVersion     string    `json:"specversion"`
+	ID              string    `json:"id"`
+	Source          string    `json:"source"`
+	Type            string    `json:"type"`
+	Subject         string    `json:"subject,omitempty"`
+	Time            time.Time `json:"time"`
+	DataContentType string    `json:"datacontenttype"`
+	Data            EventData `json:"data"`
+}
+
+// EventData is the data of an event about an object
+type EventData struct {
+	Group     string     `json:"group"`
+	Version   string     `json:"version"`
+	Kind      string     `json:"kind"`
+	Namespace string     `json:"namespace,omitempty"`
+	Name      string     `json:"name"`
+	UID       types.UID  `json:"uid"`
+	TTL       string     `json:"ttl,omitempty"`
+	Deadline  *time.Time `json:"deadline,omitempty"`
+	Owner     string     `json:"owner,omitempty"`
+	Action    string     `json:"action,omitempty"`
+	Result    string     `json:"result"`
+	Error     string     `json:"error,omitempty"`
+	Message   string     `json:"message,omitempty"`
+}
+
+// NewEvent returns the event of a notification
+func NewEvent(source string, notification Notification) Event {
+	gv, _ := schema.ParseGroupVersion(notification.Object.APIVersion)
+	subject := notification.Object.Name
+	if notification.Object.Namespace != "" {
+		subject = notification.Object.Namespace + "/" + subject
+	}
+
+	return Event{
+		SpecVersion:     cloudEventsSpecVersion,
+		ID:              string(uuid.NewUUID()),
+		Source:          source,
+		Type:            EventTypePrefix + notification.Type,
+		Subject:         subject,
+		Time:            notification.Time,
+		DataContentType: "application/json",
+		Data: EventData{
+			Group:     gv.Group,
+			Version:   gv.Version,
+			Kind:      notification.Object.Kind,
+			Namespace: notification.Object.Namespace,
+			Name:      notification.Object.Name,
+			UID:       notification.Object.UID,
+			TTL:       notification.TTL,
+			Deadline:  notification.ExpiresAt,
+			Owner:     notification.Owner,
+			Action:    notification.Action,
+			Result:    notification.Result,
+			Error:     notification.Error,
+			Message:   notification.Message,
+		},
+	}
+}
+
+// CloudEventsOptions configure a CloudEvents notifier, zero values get the defaults
+type CloudEventsOptions struct {
+	// SinkURL to POST the events to
+	SinkURL string
+	// Source attribute of the events, DefaultEventSource when empty
+	Source string
+	// Mode is ModeBinary or ModeStructured, ModeBinary when empty
+	Mode string
+	// Timeout of a request
+	Timeout time.Duration
+	// Retries of a request that failed with a network error, 429 or 5xx, none when zero
+	Retries int
+	// Backoff before the first retry, doubled on each retry
+	Backoff time.Duration
+}
+
+// CloudEvents POSTs each notification as a CloudEvent to a sink over HTTP
+type CloudEvents struct {
+	sink   string
+	source string
+	mode   string
+	sender sender
+}
+
+// NewCloudEvents validates the options and returns a CloudEvents notifier
+func NewCloudEvents(opts CloudEventsOptions) (*CloudEvents, error) {
+	if err := validateURL(opts.SinkURL); err != nil {
+		return nil, fmt.Errorf("invalid CloudEvents sink URL: %w", err)
+	}
+	c := &CloudEvents{
+		sink:   opts.SinkURL,
+		source: opts.Source,
+		mode:   opts.Mode,
+		sender: newSender(opts.Timeout, opts.Retries, opts.Backoff),
+	}
+	if c.source == "" {
+		c.source = DefaultEventSource
+	}
+	switch c.mode {
+	case "":
+		c.mode = ModeBinary
+	case ModeBinary, ModeStructured:
+	default:
+		return nil, fmt.Errorf("invalid CloudEvents mode %q, expected %s or %s", opts.Mode, ModeBinary, ModeStructured)
+	}
+
+	return c, nil
+}
+
+// Notify POSTs one event per notification, the sink has no batch format in common
+func (c *CloudEvents) Notify(ctx context.Context, notifications []Notification) error {
+	var errs []error
+	for _, notification := range notifications {
+		header, body, err := c.encode(NewEvent(c.source, notification))
+		if err == nil {
+			err = c.sender.post(ctx, c.sink, header, body)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s event of %s: %w", notification.Type, notification.Object.UID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// encode returns the headers and body of an event in the content mode
+func (c *CloudEvents) encode(event Event) (http.Header, []byte, error) {
+	if c.mode == ModeStructured {
+		body, err := json.Marshal(event)
+		return http.Header{"Content-Type": {"application/cloudevents+json"}}, body, err
+	}
+
+	body, err := json.Marshal(event.Data)
+	header := http.Header{
+		"Content-Type":   {event.DataContentType},
+		"Ce-Specversion": {event.SpecVersion},
+		"Ce-Id":          {event.ID},
+		"Ce-Source":      {event.Source},
+		"Ce-Type":        {event.Type},
+		"Ce-Time":        {event.Time.UTC().Format(time.RFC3339Nano)},
+	}
+	if event.Subject != "" {
+		header.Set("Ce-Subject", event.Subject)
+	}
+	return header, body, err
+}
@@ -0,0 +1,158 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"k8s.io/utils/ptr"
+)
+
+var _ = Describe("CloudEvents", func() {
+	var (
+		sink   *receiver
+		server *httptest.Server
+		ctx    = context.Background()
+	)
+
+	deadline := time.Date(2024, 11, 1, 18, 0, 0, 0, time.UTC)
+	reaped := Notification{
+		Type:      TypeReaped,
+		Time:      time.Date(2024, 11, 1, 18, 0, 5, 0, time.UTC),
+		Object:    ObjectReference{APIVersion: "apps/v1", Kind: "Deployment", Namespace: "ci", Name: "tmp-ttl-preview", UID: "1234"},
+		GVK:       "apps/v1/Deployment",
+		TTL:       "1d",
+		ExpiresAt: ptr.To(deadline),
+		Action:    "Delete",
+		Result:    ResultSucceeded,
+	}
+	skipped := Notification{
+		Type:   TypeSkipped,
+		Object: ObjectReference{APIVersion: "v1", Kind: "Namespace", Name: "tmp-ttl-sandbox", UID: "5678"},
+		TTL:    "forever",
+		Result: ResultSkipped,
+		Error:  "invalid TTL",
+	}
+
+	BeforeEach(func() {
+		sink = &receiver{}
+		server = httptest.NewServer(sink)
+		DeferCleanup(server.Close)
+	})
+
+	It("should POST one binary mode event per notification", func() {
+		cloudEvents, err := NewCloudEvents(CloudEventsOptions{SinkURL: server.URL, Source: "/clusters/staging"})
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(cloudEvents.Notify(ctx, []Notification{reaped, skipped})).To(Succeed())
+		Expect(sink.received()).To(Equal(2))
+
+		header := sink.requests[0].Header
+		Expect(header.Get("Content-Type")).To(Equal("application/json"))
+		Expect(header.Get("Ce-Specversion")).To(Equal("1.0"))
+		Expect(header.Get("Ce-Id")).NotTo(BeEmpty())
+		Expect(header.Get("Ce-Source")).To(Equal("/clusters/staging"))
+		Expect(header.Get("Ce-Type")).To(Equal("io.kubettlreaper.object.reaped"))
+		Expect(header.Get("Ce-Subject")).To(Equal("ci/tmp-ttl-preview"))
+		Expect(header.Get("Ce-Time")).To(Equal("2024-11-01T18:00:05Z"))
+
+		data := EventData{}
+		Expect(json.Unmarshal(sink.bodies[0], &data)).To(Succeed())
+		Expect(data).To(Equal(EventData{
+			Group: "apps", Version: "v1", Kind: "Deployment", Namespace: "ci", Name: "tmp-ttl-preview", UID: "1234",
+			TTL: "1d", Deadline: ptr.To(deadline), Action: "Delete", Result: ResultSucceeded,
+		}))
+
+		Expect(sink.requests[1].Header.Get("Ce-Type")).To(Equal("io.kubettlreaper.object.skipped"))
+		Expect(sink.requests[1].Header.Get("Ce-Subject")).To(Equal("tmp-ttl-sandbox"))
+		Expect(sink.requests[1].Header.Get("Ce-Id")).NotTo(Equal(header.Get("Ce-Id")))
+	})
+
+	It("should POST structured mode events", func() {
+		cloudEvents, err := NewCloudEvents(CloudEventsOptions{SinkURL: server.URL, Mode: ModeStructured})
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(cloudEvents.Notify(ctx, []Notification{skipped})).To(Succeed())
+		Expect(sink.requests[0].Header.Get("Content-Type")).To(Equal("application/cloudevents+json"))
+		Expect(sink.requests[0].Header.Get("Ce-Type")).To(BeEmpty())
+
+		event := Event{}
+		Expect(json.Unmarshal(sink.bodies[0], &event)).To(Succeed())
+		Expect(event.SpecVersion).To(Equal("1.0"))
+		Expect(event.Source).To(Equal(DefaultEventSource))
+		Expect(event.Type).To(Equal("io.kubettlreaper.object.skipped"))
+		Expect(event.DataContentType).To(Equal("application/json"))
+		Expect(event.Data.Version).To(Equal("v1"))
+		Expect(event.Data.Kind).To(Equal("Namespace"))
+		Expect(event.Data.Deadline).To(BeNil())
+		Expect(event.Data.Error).To(Equal("invalid TTL"))
+	})
+
+	It("should send the other events when one fails", func() {
+		sink.statuses = []int{http.StatusBadRequest}
+		cloudEvents, err := NewCloudEvents(CloudEventsOptions{SinkURL: server.URL})
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(cloudEvents.Notify(ctx, []Notification{reaped, skipped})).To(MatchError(ContainSubstring("400")))
+		Expect(sink.received()).To(Equal(2))
+	})
+
+	It("should reject invalid options", func() {
+		_, err := NewCloudEvents(CloudEventsOptions{SinkURL: "broker.knative"})
+		Expect(err).To(HaveOccurred())
+		_, err = NewCloudEvents(CloudEventsOptions{SinkURL: server.URL, Mode: "batched"})
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+// failingNotifier is a notifier that always fails
+type failingNotifier struct{ calls int }
+
+func (f *failingNotifier) Notify(context.Context, []Notification) error {
+	f.calls++
+	return errors.New("unavailable")
+}
+
+var _ = Describe("Multi", func() {
+	It("should notify every notifier and join their errors", func() {
+		first, second := &failingNotifier{}, &failingNotifier{}
+		err := Multi{first, second}.Notify(context.Background(), []Notification{{Type: TypeFailed}})
+		Expect(err).To(MatchError(ContainSubstring("unavailable")))
+		Expect(first.calls).To(Equal(1))
+		Expect(second.calls).To(Equal(1))
+	})
+
+	It("should split notifiers and webhook URLs into receivers", func() {
+		webhook, err := NewWebhook(WebhookOptions{URLs: []string{"http://a.example.com", "http://b.example.com"}})
+		Expect(err).NotTo(HaveOccurred())
+		failing := &failingNotifier{}
+
+		receivers := Receivers(Multi{webhook, failing})
+		Expect(receivers).To(HaveLen(3))
+		Expect(receivers[0].(*Webhook).urls).To(Equal([]string{"http://a.example.com"}))
+		Expect(receivers[1].(*Webhook).urls).To(Equal([]string{"http://b.example.com"}))
+		Expect(receivers[2]).To(BeIdenticalTo(failing))
+		Expect(Receivers(failing)).To(Equal([]Notifier{failing}))
+	})
+})
@@ -0,0 +1,116 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package notify
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// Types of notifications
+const (
+	// TypeExpiring warns that an object reaches its expiry soon
+	TypeExpiring = "expiring"
+	// TypeReaped tells an expired object was deleted, or had its action taken
+	TypeReaped = "reaped"
+	// TypeFailed tells deleting an expired object, or taking its action, failed
+	TypeFailed = "failed"
+	// TypeSkipped tells an expired object was left alone, e.g. its condition doesn't hold or in dry run
+	TypeSkipped = "skipped"
+)
+
+// Results of the action a notification is about
+const (
+	ResultPending   = "pending"
+	ResultSucceeded = "succeeded"
+	ResultFailed    = "failed"
+	ResultSkipped   = "skipped"
+)
+
+// ObjectReference identifies the object a notification is about
+type ObjectReference struct {
+	APIVersion string    `json:"apiVersion"`
+	Kind       string    `json:"kind"`
+	Namespace  string    `json:"namespace,omitempty"`
+	Name       string    `json:"name"`
+	UID        types.UID `json:"uid"`
+}
+
+// Notification tells the owners of an object what is about to happen or happened to it
+type Notification struct {
+	Type   string          `json:"type"`
+	Time   time.Time       `json:"time"`
+	Object ObjectReference `json:"object"`
+	// GVK of the object, e.g. apps/v1/Deployment or v1/Pod
+	GVK string `json:"gvk"`
+	// Owner is the owner annotation of the object, e.g. a team to route the notification to
+	Owner string `json:"owner,omitempty"`
+	// TTL is the TTL label of the object, empty for an absolute expiry
+	TTL string `json:"ttl,omitempty"`
+	// ExpiresAt is the computed deadline, unset when the TTL is invalid
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	// Action taken at expiry, e.g. Delete or ScaleToZero
+	Action string `json:"action"`
+	// Result of the action, pending until the object expires
+	Result  string `json:"result"`
+	Error   string `json:"error,omitempty"`
+	Message string `json:"message"`
+}
+
+// Notifier dispatches notifications, the notifications of a sweep are dispatched together
+type Notifier interface {
+	Notify(ctx context.Context, notifications []Notification) error
+}
+
+// Multi dispatches notifications to several notifiers, e.g. a webhook and a CloudEvents sink
+type Multi []Notifier
+
+// Notify dispatches the notifications to every notifier, see Receivers to tell which of
+// them failed
+func (m Multi) Notify(ctx context.Context, notifications []Notification) error {
+	var errs []error
+	for _, notifier := range m {
+		if err := notifier.Notify(ctx, notifications); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Receivers returns the receivers a notifier delivers to separately, the notifiers of a Multi
+// and the URLs of a webhook, so delivery can be tracked per receiver. Other notifiers are a
+// receiver of their own
+func Receivers(notifier Notifier) []Notifier {
+	switch n := notifier.(type) {
+	case Multi:
+		var receivers []Notifier
+		for _, child := range n {
+			receivers = append(receivers, Receivers(child)...)
+		}
+		return receivers
+	case *Webhook:
+		receivers := make([]Notifier, 0, len(n.urls))
+		for _, rawURL := range n.urls {
+			receivers = append(receivers, &Webhook{urls: []string{rawURL}, secret: n.secret, sender: n.sender})
+		}
+		return receivers
+	}
+	return []Notifier{notifier}
+}
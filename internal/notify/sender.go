@@ -0,0 +1,130 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package notify
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// HTTP defaults of the notifiers
+const (
+	defaultTimeout = 10 * time.Second
+	defaultBackoff = time.Second
+)
+
+// sender POSTs bodies, retrying transient failures with exponential backoff
+type sender struct {
+	client  *http.Client
+	retries int
+	backoff time.Duration
+}
+
+// newSender returns a sender, a zero timeout or backoff gets the default, no retries are
+// made with zero retries
+func newSender(timeout time.Duration, retries int, backoff time.Duration) sender {
+	s := sender{
+		client:  &http.Client{Timeout: defaultTimeout},
+		retries: max(retries, 0),
+		backoff: defaultBackoff,
+	}
+	if timeout > 0 {
+		s.client.Timeout = timeout
+	}
+	if backoff > 0 {
+		s.backoff = backoff
+	}
+	return s
+}
+
+// validateURL checks a URL to POST to is an absolute http or https URL
+func validateURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%q must be an http or https URL", u.Redacted())
+	}
+	return nil
+}
+
+// post sends a body to a URL, retrying network errors, 429 and 5xx responses
+func (s sender) post(ctx context.Context, rawURL string, header http.Header, body []byte) error {
+	backoff := s.backoff
+	for attempt := 0; ; attempt++ {
+		err := s.send(ctx, rawURL, header, body)
+		if err == nil || !retryable(err) || attempt == s.retries {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// send makes a single request
+func (s sender) send(ctx context.Context, rawURL string, header http.Header, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, rawURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header = header.Clone()
+	req.Header.Set("User-Agent", "kubettlreaper")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &statusError{url: req.URL.Redacted(), code: resp.StatusCode}
+	}
+	return nil
+}
+
+// statusError is a response other than 2xx
+type statusError struct {
+	url  string
+	code int
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("%s responded %d %s", e.url, e.code, http.StatusText(e.code))
+}
+
+// retryable reports whether a request may succeed if sent again, only network errors,
+// 429 and 5xx responses are retried
+func retryable(err error) bool {
+	var statusErr *statusError
+	if errors.As(err, &statusErr) {
+		return statusErr.code == http.StatusTooManyRequests || statusErr.code >= 500
+	}
+	return !errors.Is(err, context.Canceled)
+}
@@ -0,0 +1,137 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package notify
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Headers signing the requests when a secret is configured
+const (
+	// SignatureHeader carries the HMAC-SHA256 of the timestamp, a dot and the body, as sha256=<hex>
+	SignatureHeader = "X-Kubettlreaper-Signature"
+	// TimestampHeader carries when the request was signed, in Unix seconds
+	TimestampHeader = "X-Kubettlreaper-Timestamp"
+)
+
+// SignatureTolerance is how far a signed timestamp may be from the receiver's clock, receivers
+// reject requests outside of it as replays
+const SignatureTolerance = 5 * time.Minute
+
+// Payload is the JSON body POSTed to webhooks
+type Payload struct {
+	Notifications []Notification `json:"notifications"`
+}
+
+// WebhookOptions configure a webhook notifier, zero values get the defaults
+type WebhookOptions struct {
+	// URLs to POST to, each gets every notification
+	URLs []string
+	// Secret signs the requests when set
+	Secret []byte
+	// Timeout of a request
+	Timeout time.Duration
+	// Retries of a request that failed with a network error, 429 or 5xx, none when zero
+	Retries int
+	// Backoff before the first retry, doubled on each retry
+	Backoff time.Duration
+}
+
+// Webhook POSTs notifications as JSON to HTTP endpoints
+type Webhook struct {
+	urls   []string
+	secret []byte
+	sender sender
+}
+
+// NewWebhook validates the options and returns a webhook notifier
+func NewWebhook(opts WebhookOptions) (*Webhook, error) {
+	if len(opts.URLs) == 0 {
+		return nil, fmt.Errorf("no webhook URL")
+	}
+	for _, rawURL := range opts.URLs {
+		if err := validateURL(rawURL); err != nil {
+			return nil, fmt.Errorf("invalid webhook URL: %w", err)
+		}
+	}
+
+	return &Webhook{
+		urls:   opts.URLs,
+		secret: opts.Secret,
+		sender: newSender(opts.Timeout, opts.Retries, opts.Backoff),
+	}, nil
+}
+
+// Notify POSTs the notifications in a single request to each URL
+func (w *Webhook) Notify(ctx context.Context, notifications []Notification) error {
+	if len(notifications) == 0 {
+		return nil
+	}
+	body, err := json.Marshal(Payload{Notifications: notifications})
+	if err != nil {
+		return err
+	}
+	header := http.Header{"Content-Type": {"application/json"}}
+	if len(w.secret) > 0 {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		header.Set(TimestampHeader, timestamp)
+		header.Set(SignatureHeader, Sign(w.secret, timestamp, body))
+	}
+
+	var errs []error
+	for _, rawURL := range w.urls {
+		if err := w.sender.post(ctx, rawURL, header, body); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Sign returns the signature header value of a body sent at a timestamp, receivers recompute
+// it with the shared secret and compare them with hmac.Equal
+func Sign(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks the signature of a request received at now, and that its timestamp is
+// within SignatureTolerance
+func Verify(secret []byte, header http.Header, body []byte, now time.Time) error {
+	timestamp := header.Get(TimestampHeader)
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid %s %q", TimestampHeader, timestamp)
+	}
+	if skew := now.Sub(time.Unix(seconds, 0)).Abs(); skew > SignatureTolerance {
+		return fmt.Errorf("%s is %s off, more than %s", TimestampHeader, skew, SignatureTolerance)
+	}
+	if !hmac.Equal([]byte(header.Get(SignatureHeader)), []byte(Sign(secret, timestamp, body))) {
+		return fmt.Errorf("invalid %s", SignatureHeader)
+	}
+	return nil
+}
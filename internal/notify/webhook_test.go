@@ -0,0 +1,199 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package notify
+
+import (
+	"context"
+	"crypto/hmac"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"k8s.io/utils/ptr"
+)
+
+// receiver is a stand-in webhook gateway answering with scripted status codes
+type receiver struct {
+	mu       sync.Mutex
+	statuses []int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := io.ReadAll(req.Body)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.requests = append(r.requests, req)
+	r.bodies = append(r.bodies, body)
+
+	status := http.StatusOK
+	if len(r.statuses) > 0 {
+		status, r.statuses = r.statuses[0], r.statuses[1:]
+	}
+	w.WriteHeader(status)
+}
+
+func (r *receiver) received() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.requests)
+}
+
+var _ = Describe("Webhook", func() {
+	var (
+		gateway *receiver
+		server  *httptest.Server
+		ctx     = context.Background()
+	)
+
+	notifications := []Notification{{
+		Type:      TypeExpiring,
+		Time:      time.Date(2024, 11, 1, 17, 0, 0, 0, time.UTC),
+		Object:    ObjectReference{APIVersion: "v1", Kind: "Pod", Namespace: "ci", Name: "tmp-ttl-runner", UID: "1234"},
+		GVK:       "v1/Pod",
+		Owner:     "team-ci",
+		TTL:       "1d",
+		ExpiresAt: ptr.To(time.Date(2024, 11, 1, 18, 0, 0, 0, time.UTC)),
+		Action:    "Delete",
+		Result:    ResultPending,
+		Message:   "Expires in 1h",
+	}, {
+		Type:   TypeReaped,
+		Object: ObjectReference{APIVersion: "v1", Kind: "Pod", Namespace: "ci", Name: "tmp-ttl-old"},
+		Action: "Delete",
+		Result: ResultSucceeded,
+	}}
+
+	BeforeEach(func() {
+		gateway = &receiver{}
+		server = httptest.NewServer(gateway)
+		DeferCleanup(server.Close)
+	})
+
+	It("should POST the notifications in one signed JSON request", func() {
+		secret := []byte("s3cr3t")
+		webhook, err := NewWebhook(WebhookOptions{URLs: []string{server.URL}, Secret: secret})
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(webhook.Notify(ctx, notifications)).To(Succeed())
+		Expect(gateway.received()).To(Equal(1))
+
+		req, body := gateway.requests[0], gateway.bodies[0]
+		Expect(req.Method).To(Equal(http.MethodPost))
+		Expect(req.Header.Get("Content-Type")).To(Equal("application/json"))
+		timestamp := req.Header.Get(TimestampHeader)
+		Expect(hmac.Equal([]byte(req.Header.Get(SignatureHeader)), []byte(Sign(secret, timestamp, body)))).To(BeTrue())
+		Expect(Verify(secret, req.Header, body, time.Now())).To(Succeed())
+
+		payload := Payload{}
+		Expect(json.Unmarshal(body, &payload)).To(Succeed())
+		Expect(payload.Notifications).To(Equal(notifications))
+	})
+
+	It("should reject a tampered body or a timestamp outside the tolerance", func() {
+		secret := []byte("s3cr3t")
+		webhook, err := NewWebhook(WebhookOptions{URLs: []string{server.URL}, Secret: secret})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(webhook.Notify(ctx, notifications)).To(Succeed())
+		req, body := gateway.requests[0], gateway.bodies[0]
+
+		Expect(Verify(secret, req.Header, append(body, ' '), time.Now())).NotTo(Succeed())
+		Expect(Verify([]byte("other"), req.Header, body, time.Now())).NotTo(Succeed())
+		Expect(Verify(secret, req.Header, body, time.Now().Add(SignatureTolerance+time.Minute))).NotTo(Succeed())
+
+		// The timestamp is signed, so replaying the body with a fresh one fails
+		replayed := req.Header.Clone()
+		replayed.Set(TimestampHeader, strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10))
+		Expect(Verify(secret, replayed, body, time.Now().Add(time.Hour))).NotTo(Succeed())
+	})
+
+	It("should not sign without a secret or send empty batches", func() {
+		webhook, err := NewWebhook(WebhookOptions{URLs: []string{server.URL}})
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(webhook.Notify(ctx, nil)).To(Succeed())
+		Expect(gateway.received()).To(BeZero())
+
+		Expect(webhook.Notify(ctx, notifications)).To(Succeed())
+		Expect(gateway.requests[0].Header.Get(SignatureHeader)).To(BeEmpty())
+		Expect(gateway.requests[0].Header.Get(TimestampHeader)).To(BeEmpty())
+	})
+
+	It("should retry transient failures with backoff", func() {
+		gateway.statuses = []int{http.StatusServiceUnavailable, http.StatusTooManyRequests}
+		webhook, err := NewWebhook(WebhookOptions{URLs: []string{server.URL}, Retries: 3, Backoff: time.Millisecond})
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(webhook.Notify(ctx, notifications)).To(Succeed())
+		Expect(gateway.received()).To(Equal(3))
+		Expect(gateway.bodies[2]).To(Equal(gateway.bodies[0]))
+	})
+
+	It("should give up after the retries", func() {
+		gateway.statuses = []int{500, 500, 500}
+		webhook, err := NewWebhook(WebhookOptions{URLs: []string{server.URL}, Retries: 2, Backoff: time.Millisecond})
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(webhook.Notify(ctx, notifications)).To(MatchError(ContainSubstring("500")))
+		Expect(gateway.received()).To(Equal(3))
+	})
+
+	It("should not retry without retries", func() {
+		gateway.statuses = []int{http.StatusServiceUnavailable}
+		webhook, err := NewWebhook(WebhookOptions{URLs: []string{server.URL}, Backoff: time.Millisecond})
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(webhook.Notify(ctx, notifications)).To(MatchError(ContainSubstring("503")))
+		Expect(gateway.received()).To(Equal(1))
+	})
+
+	It("should not retry requests the gateway refused", func() {
+		gateway.statuses = []int{http.StatusBadRequest}
+		webhook, err := NewWebhook(WebhookOptions{URLs: []string{server.URL}, Retries: 3, Backoff: time.Millisecond})
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(webhook.Notify(ctx, notifications)).To(HaveOccurred())
+		Expect(gateway.received()).To(Equal(1))
+	})
+
+	It("should send to every URL and report those that failed", func() {
+		other := &receiver{statuses: []int{http.StatusForbidden}}
+		otherServer := httptest.NewServer(other)
+		DeferCleanup(otherServer.Close)
+
+		webhook, err := NewWebhook(WebhookOptions{URLs: []string{otherServer.URL, server.URL}})
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(webhook.Notify(ctx, notifications)).To(MatchError(ContainSubstring("403")))
+		Expect(other.received()).To(Equal(1))
+		Expect(gateway.received()).To(Equal(1))
+	})
+
+	It("should reject invalid URLs", func() {
+		for _, urls := range [][]string{nil, {"gateway.internal/hooks"}, {"ftp://gateway.internal"}, {"http://"}} {
+			_, err := NewWebhook(WebhookOptions{URLs: urls})
+			Expect(err).To(HaveOccurred(), "%v", urls)
+		}
+	})
+})
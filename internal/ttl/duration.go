@@ -0,0 +1,267 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ttl
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	Day  = 24 * time.Hour
+	Week = 7 * Day
+)
+
+// units accepted by the Go style grammar, on top of what time.ParseDuration knows
+var units = map[string]time.Duration{
+	"ns": time.Nanosecond,
+	"us": time.Microsecond,
+	"µs": time.Microsecond,
+	"ms": time.Millisecond,
+	"s":  time.Second,
+	"m":  time.Minute,
+	"h":  time.Hour,
+	"d":  Day,
+	"w":  Week,
+}
+
+// ParseDuration parses a TTL or interval. It accepts:
+//   - bare integer seconds, e.g. 3600
+//   - Go style durations extended with days and weeks, e.g. 90s, 1h30m, 7d, 2w, 1w2d12h, 1.5d
+//   - ISO-8601 durations without years or months, e.g. PT30M, P1DT12H, P2W
+//
+// Days and weeks are fixed lengths of 24h and 7d. Negative durations are rejected.
+func ParseDuration(value string) (time.Duration, error) {
+	s := strings.TrimSpace(value)
+	if s == "" {
+		return 0, fmt.Errorf("empty duration")
+	}
+
+	if seconds, err := strconv.ParseInt(s, 10, 64); err == nil {
+		if seconds < 0 {
+			return 0, fmt.Errorf("invalid duration %q: must not be negative", value)
+		}
+		if seconds > int64(math.MaxInt64/time.Second) {
+			return 0, fmt.Errorf("invalid duration %q: overflow", value)
+		}
+		return time.Duration(seconds) * time.Second, nil
+	}
+
+	var (
+		d   time.Duration
+		err error
+	)
+	if s[0] == 'P' || s[0] == 'p' {
+		d, err = parseISO8601(s)
+	} else {
+		d, err = parseUnits(s)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q: %w", value, err)
+	}
+
+	return d, nil
+}
+
+// parseUnits parses a sequence of <number><unit> pairs, e.g. 1w2d12h
+func parseUnits(s string) (time.Duration, error) {
+	if s[0] == '-' {
+		return 0, fmt.Errorf("must not be negative")
+	}
+	s = strings.TrimPrefix(s, "+")
+	if s == "" {
+		return 0, fmt.Errorf("missing value")
+	}
+
+	var total time.Duration
+	for s != "" {
+		number, rest := splitNumber(s)
+		if number == "" {
+			return 0, fmt.Errorf("expected a number at %q", s)
+		}
+
+		unitEnd := strings.IndexFunc(rest, func(r rune) bool {
+			return r == '.' || (r >= '0' && r <= '9')
+		})
+		if unitEnd < 0 {
+			unitEnd = len(rest)
+		}
+		unitName := rest[:unitEnd]
+		if unitName == "" {
+			return 0, fmt.Errorf("missing unit after %q", number)
+		}
+		unit, ok := units[unitName]
+		if !ok {
+			return 0, fmt.Errorf("unknown unit %q", unitName)
+		}
+
+		part, err := scale(number, unit)
+		if err != nil {
+			return 0, err
+		}
+		if total > math.MaxInt64-part {
+			return 0, fmt.Errorf("overflow")
+		}
+		total += part
+		s = rest[unitEnd:]
+	}
+
+	return total, nil
+}
+
+// parseISO8601 parses P[nW] or P[nD][T[nH][nM][nS]]. Years and months are rejected
+// as they don't have a fixed length.
+func parseISO8601(s string) (time.Duration, error) {
+	s = strings.ToUpper(s[1:])
+	if s == "" {
+		return 0, fmt.Errorf("missing ISO-8601 components")
+	}
+
+	var (
+		total    time.Duration
+		inTime   bool
+		seen     = map[string]bool{}
+		lastRank = -1
+	)
+	// order the designators must appear in
+	rank := map[string]int{"W": 0, "D": 1, "TH": 2, "TM": 3, "TS": 4}
+	for s != "" {
+		if s[0] == 'T' {
+			if inTime {
+				return 0, fmt.Errorf("duplicate time designator T")
+			}
+			inTime = true
+			s = s[1:]
+			if s == "" {
+				return 0, fmt.Errorf("missing time components after T")
+			}
+			continue
+		}
+
+		number, rest := splitNumber(strings.Replace(s, ",", ".", 1))
+		if number == "" || rest == "" {
+			return 0, fmt.Errorf("expected <number><designator> at %q", s)
+		}
+		designator := rest[:1]
+		key := designator
+		if inTime {
+			key = "T" + designator
+		}
+
+		var unit time.Duration
+		switch key {
+		case "W":
+			unit = Week
+		case "D":
+			unit = Day
+		case "TH":
+			unit = time.Hour
+		case "TM":
+			unit = time.Minute
+		case "TS":
+			unit = time.Second
+		case "Y", "M":
+			return 0, fmt.Errorf("years and months are not supported")
+		default:
+			return 0, fmt.Errorf("unknown designator %q", designator)
+		}
+		if seen[key] || rank[key] < lastRank {
+			return 0, fmt.Errorf("designator %q out of order", designator)
+		}
+		seen[key] = true
+		lastRank = rank[key]
+
+		part, err := scale(number, unit)
+		if err != nil {
+			return 0, err
+		}
+		if total > math.MaxInt64-part {
+			return 0, fmt.Errorf("overflow")
+		}
+		total += part
+		s = s[len(number)+1:]
+	}
+
+	return total, nil
+}
+
+// splitNumber splits a leading decimal number off s
+func splitNumber(s string) (string, string) {
+	i := 0
+	dot := false
+	for i < len(s) {
+		c := s[i]
+		if c == '.' && !dot {
+			dot = true
+		} else if c < '0' || c > '9' {
+			break
+		}
+		i++
+	}
+	if i == 0 || s[:i] == "." {
+		return "", s
+	}
+
+	return s[:i], s[i:]
+}
+
+// scale multiplies a decimal number by a unit without going through float for whole numbers
+func scale(number string, unit time.Duration) (time.Duration, error) {
+	whole, frac, _ := strings.Cut(number, ".")
+	var d time.Duration
+	if whole != "" {
+		w, err := strconv.ParseInt(whole, 10, 64)
+		if err != nil || w > int64(math.MaxInt64/unit) {
+			return 0, fmt.Errorf("overflow")
+		}
+		d = time.Duration(w) * unit
+	}
+	if frac != "" {
+		f, err := strconv.ParseFloat("0."+frac, 64)
+		if err != nil {
+			return 0, err
+		}
+		d += time.Duration(f * float64(unit))
+	}
+
+	return d, nil
+}
+
+// FormatDuration formats a duration to the second in the Go style grammar with days and
+// weeks, e.g. 1d12h or 30m, the inverse of ParseDuration
+func FormatDuration(d time.Duration) string {
+	d = d.Round(time.Second)
+	if d <= 0 {
+		return "0s"
+	}
+
+	var b strings.Builder
+	for _, unit := range []struct {
+		suffix string
+		size   time.Duration
+	}{{"w", Week}, {"d", Day}, {"h", time.Hour}, {"m", time.Minute}, {"s", time.Second}} {
+		if n := d / unit.size; n > 0 {
+			b.WriteString(strconv.FormatInt(int64(n), 10) + unit.suffix)
+			d -= n * unit.size
+		}
+	}
+
+	return b.String()
+}
@@ -0,0 +1,103 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ttl
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ParseDuration", func() {
+	DescribeTable("accepted grammar",
+		func(value string, expected time.Duration) {
+			d, err := ParseDuration(value)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(d).To(Equal(expected))
+		},
+		// bare seconds
+		Entry("integer seconds", "3600", time.Hour),
+		Entry("zero seconds", "0", time.Duration(0)),
+		// Go style
+		Entry("seconds", "90s", 90*time.Second),
+		Entry("minutes", "5m", 5*time.Minute),
+		Entry("hours and minutes", "1h30m", 90*time.Minute),
+		Entry("milliseconds", "1500ms", 1500*time.Millisecond),
+		Entry("microseconds", "10us", 10*time.Microsecond),
+		Entry("fractional hours", "1.5h", 90*time.Minute),
+		Entry("explicit plus sign", "+10s", 10*time.Second),
+		// days and weeks
+		Entry("days", "7d", 7*Day),
+		Entry("weeks", "2w", 2*Week),
+		Entry("fractional days", "1.5d", 36*time.Hour),
+		Entry("mixed units", "1w2d12h", Week+2*Day+12*time.Hour),
+		Entry("surrounding whitespace", " 1d ", Day),
+		// ISO-8601
+		Entry("ISO days and hours", "P1DT12H", 36*time.Hour),
+		Entry("ISO minutes", "PT30M", 30*time.Minute),
+		Entry("ISO weeks", "P2W", 2*Week),
+		Entry("ISO hours minutes seconds", "PT1H2M3S", time.Hour+2*time.Minute+3*time.Second),
+		Entry("ISO fractional seconds", "PT0.5S", 500*time.Millisecond),
+		Entry("ISO comma decimal", "PT1,5H", 90*time.Minute),
+		Entry("ISO lower case", "p1dt1h", 25*time.Hour),
+	)
+
+	DescribeTable("rejected grammar",
+		func(value string) {
+			_, err := ParseDuration(value)
+			Expect(err).To(HaveOccurred())
+		},
+		Entry("empty", ""),
+		Entry("negative seconds", "-10"),
+		Entry("negative duration", "-5m"),
+		Entry("missing unit", "5m10"),
+		Entry("unknown unit", "5y"),
+		Entry("unit only", "h"),
+		Entry("lone dot", ".h"),
+		Entry("ISO empty", "P"),
+		Entry("ISO empty time", "P1DT"),
+		Entry("ISO years", "P1Y"),
+		Entry("ISO months", "P1M"),
+		Entry("ISO time without T", "P1H"),
+		Entry("ISO out of order", "PT1M1H"),
+		Entry("ISO duplicate designator", "P1D2D"),
+		Entry("ISO missing designator", "PT1"),
+		Entry("overflow", "100000000w"),
+		Entry("garbage", "forever"),
+	)
+})
+
+var _ = Describe("FormatDuration", func() {
+	DescribeTable("formats in the largest units",
+		func(d time.Duration, expected string) {
+			Expect(FormatDuration(d)).To(Equal(expected))
+			if d > 0 {
+				parsed, err := ParseDuration(expected)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(parsed).To(Equal(d.Round(time.Second)))
+			}
+		},
+		Entry("zero", time.Duration(0), "0s"),
+		Entry("negative", -time.Minute, "0s"),
+		Entry("seconds", 45*time.Second, "45s"),
+		Entry("hours and minutes", 90*time.Minute, "1h30m"),
+		Entry("a day", Day, "1d"),
+		Entry("weeks and days", Week+2*Day+12*time.Hour, "1w2d12h"),
+		Entry("rounded to the second", time.Hour+400*time.Millisecond, "1h"),
+	)
+})
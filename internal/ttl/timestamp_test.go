@@ -0,0 +1,52 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ttl
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ParseTimestamp", func() {
+	DescribeTable("accepted values",
+		func(value string, expected time.Time) {
+			t, err := ParseTimestamp(value)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(t.Equal(expected)).To(BeTrue(), "got %s, want %s", t, expected)
+		},
+		Entry("RFC3339 UTC", "2024-11-01T18:00:00Z", time.Date(2024, 11, 1, 18, 0, 0, 0, time.UTC)),
+		Entry("RFC3339 with offset", "2024-11-01T18:00:00+01:00", time.Date(2024, 11, 1, 17, 0, 0, 0, time.UTC)),
+		Entry("RFC3339 with fractional seconds", "2024-11-01T18:00:00.5Z",
+			time.Date(2024, 11, 1, 18, 0, 0, 500000000, time.UTC)),
+		Entry("Unix epoch seconds", "1730484000", time.Date(2024, 11, 1, 18, 0, 0, 0, time.UTC)),
+		Entry("surrounding whitespace", " 1730484000 ", time.Date(2024, 11, 1, 18, 0, 0, 0, time.UTC)),
+	)
+
+	DescribeTable("rejected values",
+		func(value string) {
+			_, err := ParseTimestamp(value)
+			Expect(err).To(HaveOccurred())
+		},
+		Entry("empty", ""),
+		Entry("negative epoch", "-1"),
+		Entry("date only", "2024-11-01"),
+		Entry("duration", "1h"),
+		Entry("garbage", "friday"),
+	)
+})
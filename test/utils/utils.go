@@ -39,6 +39,8 @@ import (
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"kubettlreaper/api/v1alpha1"
 )
 
 const (
@@ -46,10 +48,12 @@ const (
 	prometheusOperatorURL     = "https://github.com/prometheus-operator/prometheus-operator/" +
 		"releases/download/%s/bundle.yaml"
 
-	certmanagerVersion = "v1.16.0"
-	certmanagerURLTmpl = "https://github.com/jetstack/cert-manager/releases/download/%s/cert-manager.yaml"
-	ConfigurationName  = "kube-ttl-reaper"
-	TtlLabel           = "kubettlreaper.samir.io/ttl"
+	certmanagerVersion  = "v1.16.0"
+	certmanagerURLTmpl  = "https://github.com/jetstack/cert-manager/releases/download/%s/cert-manager.yaml"
+	ConfigurationName   = "kube-ttl-reaper"
+	TtlLabel            = "kubettlreaper.samir.io/ttl"
+	ExpiresAtAnnotation = "kubettlreaper.samir.io/expires-at"
+	ConfigurationLabel  = "kubettlreaper.samir.io/configuration"
 )
 
 func warnError(err error) {
@@ -126,7 +130,35 @@ func CreateSecret(ctx context.Context, k8sClient client.Client, name, namespace,
 	return nil
 }
 
-// WaitForDeleted waits for an object to be deleted or not
+// CreateSecretWithExpiry creates a Secret with an expires-at annotation and an optional TTL label
+func CreateSecretWithExpiry(ctx context.Context, k8sClient client.Client, name, namespace, expiresAt, ttl string) error {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Annotations: map[string]string{
+				ExpiresAtAnnotation: expiresAt,
+			},
+		},
+		StringData: map[string]string{
+			"foo": "bar",
+		},
+	}
+	if ttl != "" {
+		secret.Labels = map[string]string{
+			TtlLabel: ttl,
+		}
+	}
+
+	// Create Secret
+	if err := k8sClient.Create(ctx, secret); err != nil {
+		return fmt.Errorf("failed to create Secret: %w", err)
+	}
+
+	return nil
+}
+
+// WaitForDeleted waits for an object to be deleted
 func WaitForDeleted(
 	ctx context.Context,
 	k8sClient client.Client,
@@ -149,7 +181,28 @@ func WaitForDeleted(
 	}, "30s", "5s").Should(matcher, fmt.Sprintf("%s failed for %s within timeout", msg, resource.GetName()))
 }
 
-// CreateConfigMap creates the operator configMap with sample GVKs and check-interval
+// WaitForKept checks an object is not deleted for long enough to span several sweeps
+func WaitForKept(
+	ctx context.Context,
+	k8sClient client.Client,
+	namespace string,
+	name string,
+	gvk schema.GroupVersionKind,
+	msg string,
+) bool {
+	resource := &unstructured.Unstructured{}
+	resource.SetGroupVersionKind(gvk)
+
+	return Consistently(func() error {
+		return k8sClient.Get(ctx, types.NamespacedName{
+			Namespace: namespace,
+			Name:      name,
+		}, resource)
+	}, "15s", "1s").Should(Succeed(), fmt.Sprintf("%s failed for %s, it was deleted", msg, name))
+}
+
+// CreateConfigMap creates the operator configMap with sample GVKs and check-interval, Secrets
+// are listed without the TTL label for those with only an expires-at annotation
 func CreateConfigMap(
 	ctx context.Context,
 	k8sClient client.Client,
@@ -165,6 +218,7 @@ func CreateConfigMap(
 - group: ""
   version: "v1"
   kind: "Secret"
+  list-unlabeled: true
 - group: "rbac.authorization.k8s.io"
   version: "v1"
   kind: "RoleBinding"`
@@ -178,6 +232,7 @@ func CreateConfigMap(
 		Data: map[string]string{
 			"check-interval": ttl, // Update interval as per the desired format
 			"name-prefix":    namePrefix,
+			"namespaces":     namespace, // The operator namespace is protected unless listed
 			"gvk-list":       gvkListYAML,
 		},
 	}
@@ -190,6 +245,104 @@ func CreateConfigMap(
 	return configMap, nil
 }
 
+// CreateMarkedConfigMap creates a configMap discovered by its marker label rather than its name
+func CreateMarkedConfigMap(
+	ctx context.Context,
+	k8sClient client.Client,
+	name,
+	namespace,
+	namePrefix,
+	checkInterval string,
+) (*corev1.ConfigMap, error) {
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels:    map[string]string{ConfigurationLabel: "true"},
+		},
+		Data: map[string]string{
+			"check-interval": checkInterval,
+			"name-prefix":    namePrefix,
+			"namespaces":     namespace,
+			"gvk-list": `- group: ""
+  version: "v1"
+  kind: "Secret"`,
+		},
+	}
+
+	if err := k8sClient.Create(ctx, configMap); err != nil {
+		return nil, fmt.Errorf("failed to create ConfigMap: %w", err)
+	}
+
+	return configMap, nil
+}
+
+// CreateTtlReaperPolicy creates the operator TtlReaperPolicy with the same sample GVKs as CreateConfigMap,
+// reaping in the namespace only
+func CreateTtlReaperPolicy(
+	ctx context.Context,
+	k8sClient client.Client,
+	name,
+	namespace,
+	namePrefix,
+	checkInterval string,
+) (*v1alpha1.TtlReaperPolicy, error) {
+	policy := &v1alpha1.TtlReaperPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: name,
+		},
+		Spec: v1alpha1.TtlReaperPolicySpec{
+			CheckInterval: checkInterval,
+			NamePrefix:    namePrefix,
+			Namespaces:    []string{namespace},
+			Kinds: []v1alpha1.KindRule{
+				{Version: "v1", Kind: "ConfigMap"},
+				{Version: "v1", Kind: "Secret", ListUnlabeled: true},
+				{Group: "rbac.authorization.k8s.io", Version: "v1", Kind: "RoleBinding"},
+			},
+		},
+	}
+
+	if err := k8sClient.Create(ctx, policy); err != nil {
+		return nil, fmt.Errorf("failed to create TtlReaperPolicy: %w", err)
+	}
+
+	return policy, nil
+}
+
+// CreateTtlReaperTenantPolicy creates a tenant policy giving Secrets matching the labels a default TTL
+func CreateTtlReaperTenantPolicy(
+	ctx context.Context,
+	k8sClient client.Client,
+	name,
+	namespace string,
+	matchLabels map[string]string,
+	defaultTtl string,
+) (*v1alpha1.TtlReaperTenantPolicy, error) {
+	policy := &v1alpha1.TtlReaperTenantPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Spec: v1alpha1.TtlReaperTenantPolicySpec{
+			Kinds: []v1alpha1.TenantKindRule{
+				{
+					Version:    "v1",
+					Kind:       "Secret",
+					Selector:   &metav1.LabelSelector{MatchLabels: matchLabels},
+					DefaultTtl: defaultTtl,
+				},
+			},
+		},
+	}
+
+	if err := k8sClient.Create(ctx, policy); err != nil {
+		return nil, fmt.Errorf("failed to create TtlReaperTenantPolicy: %w", err)
+	}
+
+	return policy, nil
+}
+
 // CheckEvent checks and wait for an event in a namespace
 func CheckEvent(
 	ctx context.Context,